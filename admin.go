@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mad01/pingpong/middleware/breaker"
+	"github.com/mad01/pingpong/server"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// handleAdmin serves the admin api of a service on srv when enabled:
+//
+//	/admin/config            effective config as json, GET
+//	/admin/log/level         log level as json, GET or PUT {"level": "debug"}
+//	/admin/tracing/sampling  whether traces are sampled, GET or PUT {"enabled": false}
+//	/admin/drain             drains the service, POST
+//	/admin/faults            fault config as json, GET or PUT, if faults is set
+//
+// The pinger also serves the state of its downstream connection with
+// handleDownstream.
+func handleAdmin(srv *server.Server, c *config, level zap.AtomicLevel, sampling *samplingSwitch, faults http.Handler) {
+	if !c.admin {
+		return
+	}
+	srv.Handle("/admin/config", http.HandlerFunc(serveConfig))
+	srv.Handle("/admin/log/level", level)
+	srv.Handle("/admin/tracing/sampling", sampling)
+	srv.Handle("/admin/drain", drainHandler(srv))
+	if faults != nil {
+		srv.Handle("/admin/faults", faults)
+	}
+}
+
+func serveConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeConfig(w, configValues())
+}
+
+// drainHandler drains srv on POST. The response is sent before the server
+// stops accepting requests.
+func drainHandler(srv *server.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		srv.Drain()
+		w.WriteHeader(http.StatusAccepted)
+	})
+}
+
+// writeJSON writes v as json response.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type downstreamState struct {
+	Service string `json:"service"`
+	Addr    string `json:"addr"`
+	State   string `json:"state"`
+	Breaker string `json:"breaker,omitempty"`
+}
+
+// handleDownstream serves the state of the connection of the pinger to
+// randommsg, and of its circuit breaker if any, on /admin/downstream.
+func handleDownstream(srv *server.Server, c *config, cc *grpc.ClientConn, b *breaker.Breaker) {
+	if !c.admin {
+		return
+	}
+	srv.Handle("/admin/downstream", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		state := downstreamState{
+			Service: randomMsgService,
+			Addr:    c.grpcMsgAddr,
+			State:   cc.GetState().String(),
+		}
+		if b != nil {
+			state.Breaker = b.State().String()
+		}
+		writeJSON(w, []downstreamState{state})
+	}))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/mad01/pingpong/middleware/auth"
+	"google.golang.org/grpc"
+)
+
+// authInterceptors returns the interceptors authenticating and authorizing
+// rpcs on the servers, or none when auth is disabled.
+func authInterceptors(c *config) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor, error) {
+	if !c.auth {
+		return nil, nil, nil
+	}
+
+	var apiKeys map[string]string
+	if c.authAPIKeys != "" {
+		keys, err := auth.LoadAPIKeys(c.authAPIKeys)
+		if err != nil {
+			return nil, nil, err
+		}
+		apiKeys = keys
+	}
+
+	var secret []byte
+	if c.authJWTSecretFile != "" {
+		s, err := readSecret(c.authJWTSecretFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		secret = s
+	}
+
+	var policy map[string][]string
+	if c.authPolicy != "" {
+		p, err := auth.LoadPolicy(c.authPolicy)
+		if err != nil {
+			return nil, nil, err
+		}
+		policy = p
+	}
+
+	a := auth.NewAuthenticator(apiKeys, secret, policy)
+	return []grpc.UnaryServerInterceptor{auth.UnaryServerInterceptor(a)},
+		[]grpc.StreamServerInterceptor{auth.StreamServerInterceptor(a)},
+		nil
+}
+
+// dialAuth returns the dial options attaching the credentials of the client
+// name to every rpc: the auth.token if set, or else a jwt with name as
+// subject if a jwt secret is set.
+func dialAuth(name string, c *config) ([]grpc.DialOption, error) {
+	if c.authToken != "" {
+		return []grpc.DialOption{grpc.WithPerRPCCredentials(auth.NewTokenCredentials(c.authToken, c.tls))}, nil
+	}
+	if c.authJWTSecretFile != "" {
+		secret, err := readSecret(c.authJWTSecretFile)
+		if err != nil {
+			return nil, err
+		}
+		creds := auth.NewJWTCredentials(name, secret, c.authJWTTTL, c.tls)
+		return []grpc.DialOption{grpc.WithPerRPCCredentials(creds)}, nil
+	}
+	return nil, nil
+}
+
+func readSecret(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read jwt secret: %v", err.Error())
+	}
+	secret := strings.TrimSpace(string(data))
+	if secret == "" {
+		return nil, fmt.Errorf("empty jwt secret in %v", path)
+	}
+	return []byte(secret), nil
+}
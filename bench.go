@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+	pb "github.com/mad01/pingpong/com"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// maxBenchQPS is the highest rate the bench can be paced at, one ping per
+// nanosecond tick of its ticker.
+const maxBenchQPS = int(time.Second)
+
+// benchResult holds the latencies and errors recorded by one bench worker.
+type benchResult struct {
+	latency *hdrhistogram.Histogram
+	errors  map[codes.Code]int64
+}
+
+func newBenchResult() *benchResult {
+	return &benchResult{
+		// latencies are recorded in microseconds, from 1µs up to 1 minute
+		latency: hdrhistogram.New(1, int64(time.Minute/time.Microsecond), 3),
+		errors:  map[codes.Code]int64{},
+	}
+}
+
+func (r *benchResult) merge(other *benchResult) {
+	r.latency.Merge(other.latency)
+	for code, n := range other.errors {
+		r.errors[code] += n
+	}
+}
+
+// bench sends pings to the pinger from bench.concurrency workers spread over
+// bench.conns connections, until bench.requests pings are sent or, if that is
+// 0, for bench.duration. The pings are paced at bench.qps in total when it is
+// set. Throughput, errors and latency percentiles are printed at the end and
+// the client metrics are pushed if push.url is set.
+func bench(c *config) error {
+	logger, _, err := newLogger(c)
+	if err != nil {
+		return err
+	}
+	defer logger.Sync()
+	tracer, closer, err := getTracer("bench", c)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	registry := prometheus.NewRegistry()
+	conns := make([]*grpc.ClientConn, c.benchConns)
+	for i := range conns {
+		cc, err := dialGRPC(c.grpcPingerAddr, "bench", *tracer, c, registry, logger)
+		if err != nil {
+			return err
+		}
+		defer cc.Close()
+		conns[i] = cc
+	}
+
+	ctx := context.Background()
+	if c.benchRequests == 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.benchDuration)
+		defer cancel()
+	}
+
+	// tokens paces the workers when a target qps is set
+	var tokens <-chan time.Time
+	if c.benchQPS > 0 {
+		ticker := time.NewTicker(time.Second / time.Duration(c.benchQPS))
+		defer ticker.Stop()
+		tokens = ticker.C
+	}
+
+	var sent int64
+	results := make([]*benchResult, c.benchConcurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := range results {
+		results[i] = newBenchResult()
+		wg.Add(1)
+		go func(client pb.PingerClient, result *benchResult) {
+			defer wg.Done()
+			for {
+				if tokens != nil {
+					select {
+					case <-ctx.Done():
+						return
+					case <-tokens:
+					}
+				}
+				seq := atomic.AddInt64(&sent, 1)
+				if c.benchRequests > 0 && seq > int64(c.benchRequests) {
+					return
+				}
+				if ctx.Err() != nil {
+					return
+				}
+
+				requestCtx := ctx
+				cancel := func() {}
+				if c.clientTimeout > 0 {
+					requestCtx, cancel = context.WithTimeout(ctx, c.clientTimeout)
+				}
+				requestStart := time.Now()
+				_, err := client.Ping(requestCtx, newPing(uint64(seq), c))
+				cancel()
+				if err != nil {
+					if ctx.Err() != nil {
+						// the bench ended while the ping was in flight
+						return
+					}
+					result.errors[grpc.Code(err)]++
+					continue
+				}
+				result.latency.RecordValue(int64(time.Since(requestStart) / time.Microsecond))
+			}
+		}(pb.NewPingerClient(conns[i%len(conns)]), results[i])
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	total := newBenchResult()
+	for _, result := range results {
+		total.merge(result)
+	}
+	printBenchResult(os.Stdout, total, elapsed)
+	pushMetrics(c, "bench", registry, logger)
+	return nil
+}
+
+func printBenchResult(w io.Writer, r *benchResult, elapsed time.Duration) {
+	var failed int64
+	for _, n := range r.errors {
+		failed += n
+	}
+	ok := r.latency.TotalCount()
+
+	fmt.Fprintf(w, "Requests: %v ok: %v errors: %v\n", ok+failed, ok, failed)
+	fmt.Fprintf(w, "Duration: %v\n", elapsed)
+	fmt.Fprintf(w, "Throughput: %.2f req/s\n", float64(ok+failed)/elapsed.Seconds())
+
+	if failed > 0 {
+		codeNames := make([]string, 0, len(r.errors))
+		counts := map[string]int64{}
+		for code, n := range r.errors {
+			codeNames = append(codeNames, code.String())
+			counts[code.String()] = n
+		}
+		sort.Strings(codeNames)
+		fmt.Fprintln(w, "Errors:")
+		for _, name := range codeNames {
+			fmt.Fprintf(w, "  %v: %v\n", name, counts[name])
+		}
+	}
+
+	if ok == 0 {
+		return
+	}
+	fmt.Fprintln(w, "Latency:")
+	fmt.Fprintf(w, "  min: %v\n", time.Duration(r.latency.Min())*time.Microsecond)
+	fmt.Fprintf(w, "  mean: %v\n", time.Duration(r.latency.Mean())*time.Microsecond)
+	for _, q := range []float64{50, 90, 99, 99.9} {
+		fmt.Fprintf(w, "  p%v: %v\n", q, time.Duration(r.latency.ValueAtQuantile(q))*time.Microsecond)
+	}
+	fmt.Fprintf(w, "  max: %v\n", time.Duration(r.latency.Max())*time.Microsecond)
+}
@@ -0,0 +1,189 @@
+// Package certs provides tls configs for the grpc servers and clients whose
+// certificate and CA are reloaded from disk when the files change, so that
+// certificates can be rotated without restarting.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Reloader holds a certificate and CA pool loaded from files. The files are
+// checked for changes at most once per interval, during a tls handshake.
+type Reloader struct {
+	certFile string
+	keyFile  string
+	caFile   string
+	interval time.Duration
+	logger   *zap.Logger
+
+	mu      sync.Mutex
+	checked time.Time
+	loaded  [3]time.Time
+	cert    *tls.Certificate
+	pool    *x509.CertPool
+}
+
+// NewReloader loads the certificate in certFile and keyFile and the CA
+// certificates in caFile. The certificate or the CA may be left out by
+// passing empty paths. Failed reloads are logged with logger.
+func NewReloader(certFile, keyFile, caFile string, interval time.Duration, logger *zap.Logger) (*Reloader, error) {
+	r := &Reloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		interval: interval,
+		logger:   logger,
+	}
+	modTimes, err := r.modTimes()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTimes); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// ServerConfig returns a tls config presenting the current certificate. If
+// clientAuth is set clients must present a certificate signed by the CA.
+func (r *Reloader) ServerConfig(clientAuth bool) *tls.Config {
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := r.current()
+			if cert == nil {
+				return nil, fmt.Errorf("no server certificate loaded")
+			}
+			cfg := &tls.Config{
+				Certificates: []tls.Certificate{*cert},
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   []string{"h2"},
+			}
+			if clientAuth {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = pool
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a tls config verifying that the server presents a
+// certificate for serverName signed by the CA, or by the system roots when
+// no CA is set. The current certificate, if any, is presented to servers
+// asking for one.
+func (r *Reloader) ClientConfig(serverName string) *tls.Config {
+	return &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+		// the chain is verified in VerifyPeerCertificate against the
+		// current CA pool, as RootCAs can't be swapped once set
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			_, pool := r.current()
+			return verify(rawCerts, serverName, pool)
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := r.current()
+			if cert == nil {
+				return &tls.Certificate{}, nil
+			}
+			return cert, nil
+		},
+	}
+}
+
+func verify(rawCerts [][]byte, serverName string, pool *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("server presented no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse server certificate: %v", err.Error())
+		}
+		certs[i] = cert
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         pool,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(opts)
+	return err
+}
+
+// current returns the loaded certificate and CA pool, reloading them first
+// if the files changed. A failed reload keeps the previous files in use.
+func (r *Reloader) current() (*tls.Certificate, *x509.CertPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.checked) >= r.interval {
+		r.checked = time.Now()
+		modTimes, err := r.modTimes()
+		if err == nil && modTimes != r.loaded {
+			err = r.load(modTimes)
+		}
+		if err != nil {
+			r.logger.Warn("failed to reload certificates, keeping the previous ones", zap.Error(err))
+		}
+	}
+	return r.cert, r.pool
+}
+
+func (r *Reloader) modTimes() ([3]time.Time, error) {
+	var modTimes [3]time.Time
+	for i, path := range []string{r.certFile, r.keyFile, r.caFile} {
+		if path == "" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			return modTimes, err
+		}
+		modTimes[i] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (r *Reloader) load(modTimes [3]time.Time) error {
+	var cert *tls.Certificate
+	if r.certFile != "" {
+		c, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load certificate: %v", err.Error())
+		}
+		cert = &c
+	}
+
+	var pool *x509.CertPool
+	if r.caFile != "" {
+		data, err := ioutil.ReadFile(r.caFile)
+		if err != nil {
+			return fmt.Errorf("failed to read ca: %v", err.Error())
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("no certificates found in ca %v", r.caFile)
+		}
+	}
+
+	r.cert = cert
+	r.pool = pool
+	r.loaded = modTimes
+	r.checked = time.Now()
+	return nil
+}
@@ -0,0 +1,281 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// testCA signs certificates for the tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{
+		cert: cert,
+		key:  key,
+		pem:  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+	}
+}
+
+// issue returns the pem encoded certificate and key of a new certificate
+// for dnsName signed by the CA.
+func (ca *testCA) issue(t *testing.T, serial int64, dnsName string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile writes data to name in dir and returns its path. The mod time
+// is set to modTime, as rewrites within the resolution of the file system
+// would otherwise go unnoticed.
+func writeFile(t *testing.T, dir, name string, data []byte, modTime time.Time) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// handshake runs a tls handshake between server and client and returns the
+// errors of both sides and the certificate the client was presented.
+func handshake(t *testing.T, server, client *tls.Config) (error, error, *x509.Certificate) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverConn, err := lis.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer serverConn.Close()
+		conn := tls.Server(serverConn, server)
+		err = conn.Handshake()
+		if err == nil {
+			// a rejected client certificate is only reported when reading
+			// with tls 1.3
+			conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+			_, err = conn.Read(make([]byte, 1))
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				err = nil
+			}
+		}
+		serverErr <- err
+	}()
+
+	clientConn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clientConn.Close()
+	conn := tls.Client(clientConn, client)
+	clientErr := conn.Handshake()
+	var peer *x509.Certificate
+	if certs := conn.ConnectionState().PeerCertificates; len(certs) > 0 {
+		peer = certs[0]
+	}
+	return <-serverErr, clientErr, peer
+}
+
+func TestHandshake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	ca := newTestCA(t, "ca")
+	otherCA := newTestCA(t, "other ca")
+	caFile := writeFile(t, dir, "ca.pem", ca.pem, now)
+	otherCAFile := writeFile(t, dir, "other-ca.pem", otherCA.pem, now)
+	serverCert, serverKey := ca.issue(t, 2, "pinger.local")
+	serverCertFile := writeFile(t, dir, "server.pem", serverCert, now)
+	serverKeyFile := writeFile(t, dir, "server-key.pem", serverKey, now)
+	clientCert, clientKey := ca.issue(t, 3, "client.local")
+	clientCertFile := writeFile(t, dir, "client.pem", clientCert, now)
+	clientKeyFile := writeFile(t, dir, "client-key.pem", clientKey, now)
+
+	server, err := NewReloader(serverCertFile, serverKeyFile, caFile, time.Minute, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		clientAuth bool
+		serverName string
+		caFile     string
+		clientCert bool
+		serverErr  bool
+		clientErr  bool
+	}{
+		{
+			name:       "ok",
+			serverName: "pinger.local",
+			caFile:     caFile,
+		},
+		{
+			name:       "client auth",
+			clientAuth: true,
+			serverName: "pinger.local",
+			caFile:     caFile,
+			clientCert: true,
+		},
+		{
+			name:       "wrong ca",
+			serverName: "pinger.local",
+			caFile:     otherCAFile,
+			clientErr:  true,
+		},
+		{
+			name:       "wrong hostname",
+			serverName: "randommsg.local",
+			caFile:     caFile,
+			clientErr:  true,
+		},
+		{
+			name:       "missing client certificate",
+			clientAuth: true,
+			serverName: "pinger.local",
+			caFile:     caFile,
+			serverErr:  true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			certFile, keyFile := "", ""
+			if tc.clientCert {
+				certFile, keyFile = clientCertFile, clientKeyFile
+			}
+			client, err := NewReloader(certFile, keyFile, tc.caFile, time.Minute, zap.NewNop())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			serverErr, clientErr, _ := handshake(t, server.ServerConfig(tc.clientAuth), client.ClientConfig(tc.serverName))
+			if (clientErr != nil) != tc.clientErr {
+				t.Errorf("got client error %v, want error %v", clientErr, tc.clientErr)
+			}
+			if !tc.clientErr && (serverErr != nil) != tc.serverErr {
+				t.Errorf("got server error %v, want error %v", serverErr, tc.serverErr)
+			}
+		})
+	}
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "certs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	ca := newTestCA(t, "ca")
+	caFile := writeFile(t, dir, "ca.pem", ca.pem, now)
+	cert, key := ca.issue(t, 2, "pinger.local")
+	certFile := writeFile(t, dir, "server.pem", cert, now)
+	keyFile := writeFile(t, dir, "server-key.pem", key, now)
+
+	// an interval of 0 checks the files on every handshake
+	server, err := NewReloader(certFile, keyFile, "", 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := NewReloader("", "", caFile, 0, zap.NewNop())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serial := func() int64 {
+		_, clientErr, peer := handshake(t, server.ServerConfig(false), client.ClientConfig("pinger.local"))
+		if clientErr != nil {
+			t.Fatalf("handshake failed: %v", clientErr)
+		}
+		return peer.SerialNumber.Int64()
+	}
+
+	if got := serial(); got != 2 {
+		t.Fatalf("got serial %v, want 2", got)
+	}
+
+	cert, key = ca.issue(t, 3, "pinger.local")
+	writeFile(t, dir, "server.pem", cert, now.Add(time.Second))
+	writeFile(t, dir, "server-key.pem", key, now.Add(time.Second))
+	if got := serial(); got != 3 {
+		t.Errorf("got serial %v after the rewrite, want 3", got)
+	}
+
+	// a broken rewrite keeps the previous pair
+	writeFile(t, dir, "server.pem", []byte("broken"), now.Add(2*time.Second))
+	if got := serial(); got != 3 {
+		t.Errorf("got serial %v after a broken rewrite, want 3", got)
+	}
+}
@@ -10,6 +10,7 @@ It is generated from these files:
 It has these top-level messages:
 	PingRequest
 	PongResponse
+	PingStreamRequest
 	RandomMsgRequest
 	RandomMsgResponse
 */
@@ -37,6 +38,14 @@ const _ = proto.ProtoPackageIsVersion2 // please upgrade the proto package
 
 type PingRequest struct {
 	Msg string `protobuf:"bytes,1,opt,name=msg" json:"msg,omitempty"`
+	// sequence number of the ping, echoed in the pong
+	Seq uint64 `protobuf:"varint,2,opt,name=seq" json:"seq,omitempty"`
+	// unix time in nanoseconds the client sent the ping, echoed in the pong
+	ClientSendUnixNano int64 `protobuf:"varint,3,opt,name=client_send_unix_nano,json=clientSendUnixNano" json:"client_send_unix_nano,omitempty"`
+	// number of padding bytes the server adds to the pong
+	ResponseSize uint32 `protobuf:"varint,4,opt,name=response_size,json=responseSize" json:"response_size,omitempty"`
+	// ignored by the server, used to send large pings
+	Padding []byte `protobuf:"bytes,5,opt,name=padding,proto3" json:"padding,omitempty"`
 }
 
 func (m *PingRequest) Reset()                    { *m = PingRequest{} }
@@ -51,8 +60,46 @@ func (m *PingRequest) GetMsg() string {
 	return ""
 }
 
+func (m *PingRequest) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *PingRequest) GetClientSendUnixNano() int64 {
+	if m != nil {
+		return m.ClientSendUnixNano
+	}
+	return 0
+}
+
+func (m *PingRequest) GetResponseSize() uint32 {
+	if m != nil {
+		return m.ResponseSize
+	}
+	return 0
+}
+
+func (m *PingRequest) GetPadding() []byte {
+	if m != nil {
+		return m.Padding
+	}
+	return nil
+}
+
 type PongResponse struct {
 	Msg string `protobuf:"bytes,1,opt,name=msg" json:"msg,omitempty"`
+	// unix time in nanoseconds the server received the ping
+	ServerReceiveUnixNano int64 `protobuf:"varint,2,opt,name=server_receive_unix_nano,json=serverReceiveUnixNano" json:"server_receive_unix_nano,omitempty"`
+	// unix time in nanoseconds the server sent the pong
+	ServerSendUnixNano int64 `protobuf:"varint,3,opt,name=server_send_unix_nano,json=serverSendUnixNano" json:"server_send_unix_nano,omitempty"`
+	// sequence number of the ping this pong answers
+	Seq uint64 `protobuf:"varint,4,opt,name=seq" json:"seq,omitempty"`
+	// unix time in nanoseconds the client sent the ping
+	ClientSendUnixNano int64 `protobuf:"varint,5,opt,name=client_send_unix_nano,json=clientSendUnixNano" json:"client_send_unix_nano,omitempty"`
+	// response_size bytes of padding requested by the ping
+	Padding []byte `protobuf:"bytes,6,opt,name=padding,proto3" json:"padding,omitempty"`
 }
 
 func (m *PongResponse) Reset()                    { *m = PongResponse{} }
@@ -67,13 +114,82 @@ func (m *PongResponse) GetMsg() string {
 	return ""
 }
 
+func (m *PongResponse) GetServerReceiveUnixNano() int64 {
+	if m != nil {
+		return m.ServerReceiveUnixNano
+	}
+	return 0
+}
+
+func (m *PongResponse) GetServerSendUnixNano() int64 {
+	if m != nil {
+		return m.ServerSendUnixNano
+	}
+	return 0
+}
+
+func (m *PongResponse) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *PongResponse) GetClientSendUnixNano() int64 {
+	if m != nil {
+		return m.ClientSendUnixNano
+	}
+	return 0
+}
+
+func (m *PongResponse) GetPadding() []byte {
+	if m != nil {
+		return m.Padding
+	}
+	return nil
+}
+
+type PingStreamRequest struct {
+	Msg string `protobuf:"bytes,1,opt,name=msg" json:"msg,omitempty"`
+	// number of pongs to send, 0 sends pongs until the client cancels
+	Count uint32 `protobuf:"varint,2,opt,name=count" json:"count,omitempty"`
+	// time between pongs in milliseconds
+	IntervalMs uint32 `protobuf:"varint,3,opt,name=interval_ms,json=intervalMs" json:"interval_ms,omitempty"`
+}
+
+func (m *PingStreamRequest) Reset()                    { *m = PingStreamRequest{} }
+func (m *PingStreamRequest) String() string            { return proto.CompactTextString(m) }
+func (*PingStreamRequest) ProtoMessage()               {}
+func (*PingStreamRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+
+func (m *PingStreamRequest) GetMsg() string {
+	if m != nil {
+		return m.Msg
+	}
+	return ""
+}
+
+func (m *PingStreamRequest) GetCount() uint32 {
+	if m != nil {
+		return m.Count
+	}
+	return 0
+}
+
+func (m *PingStreamRequest) GetIntervalMs() uint32 {
+	if m != nil {
+		return m.IntervalMs
+	}
+	return 0
+}
+
 type RandomMsgRequest struct {
 }
 
 func (m *RandomMsgRequest) Reset()                    { *m = RandomMsgRequest{} }
 func (m *RandomMsgRequest) String() string            { return proto.CompactTextString(m) }
 func (*RandomMsgRequest) ProtoMessage()               {}
-func (*RandomMsgRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{2} }
+func (*RandomMsgRequest) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
 
 type RandomMsgResponse struct {
 	Msg string `protobuf:"bytes,1,opt,name=msg" json:"msg,omitempty"`
@@ -82,7 +198,7 @@ type RandomMsgResponse struct {
 func (m *RandomMsgResponse) Reset()                    { *m = RandomMsgResponse{} }
 func (m *RandomMsgResponse) String() string            { return proto.CompactTextString(m) }
 func (*RandomMsgResponse) ProtoMessage()               {}
-func (*RandomMsgResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{3} }
+func (*RandomMsgResponse) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{4} }
 
 func (m *RandomMsgResponse) GetMsg() string {
 	if m != nil {
@@ -94,6 +210,7 @@ func (m *RandomMsgResponse) GetMsg() string {
 func init() {
 	proto.RegisterType((*PingRequest)(nil), "com.PingRequest")
 	proto.RegisterType((*PongResponse)(nil), "com.PongResponse")
+	proto.RegisterType((*PingStreamRequest)(nil), "com.PingStreamRequest")
 	proto.RegisterType((*RandomMsgRequest)(nil), "com.RandomMsgRequest")
 	proto.RegisterType((*RandomMsgResponse)(nil), "com.RandomMsgResponse")
 }
@@ -110,6 +227,10 @@ const _ = grpc.SupportPackageIsVersion4
 
 type PingerClient interface {
 	Ping(ctx context.Context, in *PingRequest, opts ...grpc.CallOption) (*PongResponse, error)
+	// PingStream sends count pongs, one every interval_ms
+	PingStream(ctx context.Context, in *PingStreamRequest, opts ...grpc.CallOption) (Pinger_PingStreamClient, error)
+	// PingPong echoes every ping on the stream with a pong
+	PingPong(ctx context.Context, opts ...grpc.CallOption) (Pinger_PingPongClient, error)
 }
 
 type pingerClient struct {
@@ -129,10 +250,77 @@ func (c *pingerClient) Ping(ctx context.Context, in *PingRequest, opts ...grpc.C
 	return out, nil
 }
 
+func (c *pingerClient) PingStream(ctx context.Context, in *PingStreamRequest, opts ...grpc.CallOption) (Pinger_PingStreamClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Pinger_serviceDesc.Streams[0], c.cc, "/com.Pinger/PingStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pingerPingStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Pinger_PingStreamClient interface {
+	Recv() (*PongResponse, error)
+	grpc.ClientStream
+}
+
+type pingerPingStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *pingerPingStreamClient) Recv() (*PongResponse, error) {
+	m := new(PongResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *pingerClient) PingPong(ctx context.Context, opts ...grpc.CallOption) (Pinger_PingPongClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_Pinger_serviceDesc.Streams[1], c.cc, "/com.Pinger/PingPong", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &pingerPingPongClient{stream}
+	return x, nil
+}
+
+type Pinger_PingPongClient interface {
+	Send(*PingRequest) error
+	Recv() (*PongResponse, error)
+	grpc.ClientStream
+}
+
+type pingerPingPongClient struct {
+	grpc.ClientStream
+}
+
+func (x *pingerPingPongClient) Send(m *PingRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *pingerPingPongClient) Recv() (*PongResponse, error) {
+	m := new(PongResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for Pinger service
 
 type PingerServer interface {
 	Ping(context.Context, *PingRequest) (*PongResponse, error)
+	// PingStream sends count pongs, one every interval_ms
+	PingStream(*PingStreamRequest, Pinger_PingStreamServer) error
+	// PingPong echoes every ping on the stream with a pong
+	PingPong(Pinger_PingPongServer) error
 }
 
 func RegisterPingerServer(s *grpc.Server, srv PingerServer) {
@@ -157,6 +345,53 @@ func _Pinger_Ping_Handler(srv interface{}, ctx context.Context, dec func(interfa
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Pinger_PingStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(PingStreamRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(PingerServer).PingStream(m, &pingerPingStreamServer{stream})
+}
+
+type Pinger_PingStreamServer interface {
+	Send(*PongResponse) error
+	grpc.ServerStream
+}
+
+type pingerPingStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *pingerPingStreamServer) Send(m *PongResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Pinger_PingPong_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(PingerServer).PingPong(&pingerPingPongServer{stream})
+}
+
+type Pinger_PingPongServer interface {
+	Send(*PongResponse) error
+	Recv() (*PingRequest, error)
+	grpc.ServerStream
+}
+
+type pingerPingPongServer struct {
+	grpc.ServerStream
+}
+
+func (x *pingerPingPongServer) Send(m *PongResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *pingerPingPongServer) Recv() (*PingRequest, error) {
+	m := new(PingRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _Pinger_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "com.Pinger",
 	HandlerType: (*PingerServer)(nil),
@@ -166,7 +401,19 @@ var _Pinger_serviceDesc = grpc.ServiceDesc{
 			Handler:    _Pinger_Ping_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "PingStream",
+			Handler:       _Pinger_PingStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PingPong",
+			Handler:       _Pinger_PingPong_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "com.proto",
 }
 
@@ -237,16 +484,30 @@ var _RandomMsg_serviceDesc = grpc.ServiceDesc{
 func init() { proto.RegisterFile("com.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 170 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xe2, 0xe2, 0x4c, 0xce, 0xcf, 0xd5,
-	0x2b, 0x28, 0xca, 0x2f, 0xc9, 0x17, 0x62, 0x4e, 0xce, 0xcf, 0x55, 0x92, 0xe7, 0xe2, 0x0e, 0xc8,
-	0xcc, 0x4b, 0x0f, 0x4a, 0x2d, 0x2c, 0x4d, 0x2d, 0x2e, 0x11, 0x12, 0xe0, 0x62, 0xce, 0x2d, 0x4e,
-	0x97, 0x60, 0x54, 0x60, 0xd4, 0xe0, 0x0c, 0x02, 0x31, 0x95, 0x14, 0xb8, 0x78, 0x02, 0xf2, 0x41,
-	0x0a, 0x8a, 0x0b, 0xf2, 0xf3, 0x8a, 0x53, 0xb1, 0xa8, 0x10, 0xe2, 0x12, 0x08, 0x4a, 0xcc, 0x4b,
-	0xc9, 0xcf, 0xf5, 0x2d, 0x86, 0x99, 0xa3, 0xa4, 0xca, 0x25, 0x88, 0x24, 0x86, 0x4b, 0xab, 0x91,
-	0x39, 0x17, 0x1b, 0xc8, 0xf6, 0xd4, 0x22, 0x21, 0x5d, 0x2e, 0x16, 0x10, 0x4b, 0x48, 0x40, 0x0f,
-	0xe4, 0x40, 0x24, 0x27, 0x49, 0x09, 0x42, 0x44, 0x90, 0xdc, 0xa0, 0xc4, 0x60, 0xe4, 0xc3, 0xc5,
-	0x09, 0x37, 0x5f, 0xc8, 0x9e, 0x8b, 0xc7, 0x3d, 0xb5, 0x04, 0xc1, 0x17, 0x05, 0xeb, 0x40, 0x77,
-	0x93, 0x94, 0x18, 0xba, 0x30, 0xcc, 0xb4, 0x24, 0x36, 0x70, 0x80, 0x18, 0x03, 0x02, 0x00, 0x00,
-	0xff, 0xff, 0x85, 0x41, 0xbd, 0xb6, 0x1d, 0x01, 0x00, 0x00,
+	// 395 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x8c, 0x53, 0x41, 0xcb, 0xda, 0x40,
+	0x10, 0xed, 0x9a, 0x68, 0xeb, 0x98, 0x80, 0x2e, 0x55, 0x82, 0x97, 0x86, 0x94, 0x42, 0x2e, 0x15,
+	0xb5, 0x94, 0x1e, 0x7a, 0xe8, 0xb1, 0x97, 0x5a, 0x64, 0xa5, 0xb7, 0x42, 0x48, 0x93, 0x41, 0x16,
+	0xcc, 0xae, 0xee, 0x46, 0x11, 0xff, 0x4e, 0x8f, 0xfd, 0x69, 0xfd, 0x13, 0x1f, 0x9b, 0x18, 0x8d,
+	0x62, 0xc0, 0xdb, 0xcc, 0x7b, 0x3b, 0xc9, 0x7b, 0x6f, 0x76, 0xa1, 0x9b, 0xc8, 0x6c, 0xb2, 0x55,
+	0x32, 0x97, 0xd4, 0x4a, 0x64, 0x16, 0xfc, 0x25, 0xd0, 0x5b, 0x72, 0xb1, 0x66, 0xb8, 0xdb, 0xa3,
+	0xce, 0x69, 0x1f, 0xac, 0x4c, 0xaf, 0x3d, 0xe2, 0x93, 0xb0, 0xcb, 0x4c, 0x69, 0x10, 0x8d, 0x3b,
+	0xaf, 0xe5, 0x93, 0xd0, 0x66, 0xa6, 0xa4, 0x33, 0x18, 0x26, 0x1b, 0x8e, 0x22, 0x8f, 0x34, 0x8a,
+	0x34, 0xda, 0x0b, 0x7e, 0x8c, 0x44, 0x2c, 0xa4, 0x67, 0xf9, 0x24, 0xb4, 0x18, 0x2d, 0xc9, 0x15,
+	0x8a, 0xf4, 0x97, 0xe0, 0xc7, 0x9f, 0xb1, 0x90, 0xf4, 0x3d, 0xb8, 0x0a, 0xf5, 0x56, 0x0a, 0x8d,
+	0x91, 0xe6, 0x27, 0xf4, 0x6c, 0x9f, 0x84, 0x2e, 0x73, 0x2a, 0x70, 0xc5, 0x4f, 0x48, 0x3d, 0x78,
+	0xbd, 0x8d, 0xd3, 0x94, 0x8b, 0xb5, 0xd7, 0xf6, 0x49, 0xe8, 0xb0, 0xaa, 0x0d, 0xfe, 0x13, 0x70,
+	0x96, 0xd2, 0xa8, 0x2c, 0x8f, 0x3f, 0x90, 0xf9, 0x05, 0x3c, 0x8d, 0xea, 0x80, 0x2a, 0x52, 0x98,
+	0x20, 0x3f, 0x60, 0x4d, 0x57, 0xab, 0xd0, 0x35, 0x2c, 0x79, 0x56, 0xd2, 0x17, 0x69, 0x33, 0x38,
+	0x13, 0x0d, 0x6e, 0x4a, 0xf2, 0xc6, 0xcd, 0x39, 0x12, 0xfb, 0x89, 0x48, 0xda, 0x8d, 0x91, 0xd4,
+	0xdc, 0x76, 0x6e, 0xdd, 0xfe, 0x86, 0x81, 0x59, 0xc9, 0x2a, 0x57, 0x18, 0x67, 0xcd, 0x8b, 0x79,
+	0x0b, 0xed, 0x44, 0xee, 0x45, 0x5e, 0xd8, 0x73, 0x59, 0xd9, 0xd0, 0x77, 0xd0, 0xe3, 0x22, 0x47,
+	0x75, 0x88, 0x37, 0x51, 0xa6, 0x0b, 0x13, 0x2e, 0x83, 0x0a, 0x5a, 0xe8, 0x80, 0x42, 0x9f, 0xc5,
+	0x22, 0x95, 0xd9, 0x42, 0x57, 0x5b, 0x0f, 0x3e, 0xc0, 0xa0, 0x86, 0x35, 0x65, 0x3c, 0xff, 0x47,
+	0xa0, 0x63, 0x94, 0xa1, 0xa2, 0x1f, 0xc1, 0x36, 0x15, 0xed, 0x4f, 0xcc, 0x85, 0xaa, 0xdd, 0xa0,
+	0xf1, 0xa0, 0x44, 0x6a, 0xdb, 0x0a, 0x5e, 0xd1, 0xaf, 0x00, 0x57, 0x4b, 0x74, 0x74, 0x19, 0xba,
+	0xf1, 0xf8, 0x70, 0x74, 0x4a, 0xe8, 0x67, 0x78, 0x63, 0xce, 0x2e, 0xe5, 0xd3, 0xff, 0x0b, 0xc9,
+	0x94, 0xcc, 0x7f, 0x40, 0xf7, 0x62, 0x8a, 0x7e, 0x03, 0xe7, 0x3b, 0xe6, 0xd7, 0x7e, 0x58, 0x4c,
+	0xdd, 0x07, 0x31, 0x1e, 0xdd, 0xc3, 0xd5, 0x17, 0xff, 0x74, 0x8a, 0x47, 0xf3, 0xe9, 0x25, 0x00,
+	0x00, 0xff, 0xff, 0x22, 0x40, 0x20, 0x30, 0x41, 0x03, 0x00, 0x00,
 }
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mad01/pingpong/middleware/fault"
+	"github.com/mad01/pingpong/middleware/limit"
+	"github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+)
+
+// envPrefix is prepended to the environment variable of every flag, e.g.
+// grpc.ping.addr is read from PINGPONG_GRPC_PING_ADDR.
+const envPrefix = "PINGPONG_"
+
+type config struct {
+	server         bool
+	role           string
+	clinet         bool
+	msg            string
+	grpcPingerAddr string
+	httpPingerAddr string
+	httpMsgAddr    string
+	grpcMsgAddr    string
+	Version        bool
+
+	pingMode         string
+	pingCount        int
+	pingInterval     time.Duration
+	pingSize         int
+	pingResponseSize int
+
+	bench            bool
+	benchConcurrency int
+	benchConns       int
+	benchRequests    int
+	benchDuration    time.Duration
+	benchQPS         int
+
+	shutdownTimeout time.Duration
+	defaultTimeout  time.Duration
+	clientTimeout   time.Duration
+
+	tls               bool
+	tlsCert           string
+	tlsKey            string
+	tlsCA             string
+	tlsClientAuth     bool
+	tlsServerName     string
+	tlsReloadInterval time.Duration
+
+	retryMaxAttempts       int
+	retryPerAttemptTimeout time.Duration
+	retryBackoffBase       time.Duration
+	retryBackoffMax        time.Duration
+	retryCodes             string
+	retryBudgetTokens      float64
+	retryBudgetRatio       float64
+
+	breaker                 bool
+	breakerWindow           time.Duration
+	breakerMinRequests      int
+	breakerErrorRate        float64
+	breakerLatency          time.Duration
+	breakerOpenTimeout      time.Duration
+	breakerHalfOpenRequests int
+	breakerFallbackMsg      string
+
+	auth              bool
+	authAPIKeys       string
+	authJWTSecretFile string
+	authJWTTTL        time.Duration
+	authPolicy        string
+	authToken         string
+
+	limitRate        float64
+	limitBurst       int
+	limitClientRate  float64
+	limitClientBurst int
+	limitConcurrency int
+
+	shed             bool
+	shedInitialLimit int
+	shedMinLimit     int
+	shedMaxLimit     int
+	shedTolerance    float64
+	priority         string
+
+	faults        bool
+	faultsConfig  string
+	faultsRequest string
+
+	admin bool
+
+	metricsPingBuckets string
+	metricsMsgBuckets  string
+	metricsQuantiles   string
+
+	pushURL     string
+	pushJob     string
+	pushTimeout time.Duration
+
+	configFile  string
+	printConfig bool
+
+	logLevel    string
+	logEncoding string
+
+	samplerType           string
+	samplerParam          float64
+	samplingServerURL     string
+	agentAddr             string
+	reporterQueueSize     int
+	reporterFlushInterval time.Duration
+	logSpans              bool
+}
+
+// newServerCmd parses the configuration from, in order of precedence, the
+// command line flags, PINGPONG_* environment variables, the -config file and
+// the flag defaults.
+func newServerCmd() (*config, error) {
+	c := new(config)
+	flag.StringVar(&c.grpcPingerAddr, "grpc.ping.addr", "0.0.0.0:8881", "grpc ping server port")
+	flag.StringVar(&c.httpPingerAddr, "http.ping.addr", "0.0.0.0:8882", "http ping server port")
+	flag.StringVar(&c.grpcMsgAddr, "grpc.msg.addr", "0.0.0.0:8883", "grpc msg server port")
+	flag.StringVar(&c.httpMsgAddr, "http.msg.addr", "0.0.0.0:8884", "http msg server port")
+	flag.BoolVar(&c.Version, "version", false, "show version")
+	flag.BoolVar(&c.server, "server", false, "run as server")
+	flag.StringVar(&c.role, "role", "all", "services to run as server: pinger, randommsg or all")
+	flag.BoolVar(&c.clinet, "client", false, "run as client")
+	flag.StringVar(&c.msg, "msg", "foobar", "message to send in ping")
+	flag.StringVar(&c.pingMode, "ping.mode", "unary", "client ping rpc: unary, stream or pingpong")
+	flag.IntVar(&c.pingCount, "ping.count", 5, "pongs to receive in stream and pingpong mode")
+	flag.DurationVar(&c.pingInterval, "ping.interval", time.Second, "time between pongs in stream and pingpong mode")
+	flag.IntVar(&c.pingSize, "ping.size", 0, "padding bytes to add to every ping")
+	flag.IntVar(&c.pingResponseSize, "ping.response-size", 0, "padding bytes the server adds to every pong")
+	flag.BoolVar(&c.bench, "bench", false, "run as client sending pings at load and report latency")
+	flag.IntVar(&c.benchConcurrency, "bench.concurrency", 10, "pings in flight at the same time")
+	flag.IntVar(&c.benchConns, "bench.conns", 1, "connections to spread the pings over")
+	flag.IntVar(&c.benchRequests, "bench.requests", 0, "pings to send, 0 runs for bench.duration")
+	flag.DurationVar(&c.benchDuration, "bench.duration", 10*time.Second, "time to send pings for when bench.requests is 0")
+	flag.IntVar(&c.benchQPS, "bench.qps", 0, "target pings per second over all workers, 0 is unlimited")
+	flag.DurationVar(&c.shutdownTimeout, "shutdown.timeout", 10*time.Second, "time to drain in-flight requests on shutdown")
+	flag.DurationVar(&c.defaultTimeout, "server.default-timeout", time.Second, "timeout of unary rpcs whose caller sent no deadline, 0 leaves them unbounded")
+	flag.DurationVar(&c.clientTimeout, "client.timeout", 5*time.Second, "deadline of unary pings sent by the client and bench, 0 sends no deadline")
+	flag.BoolVar(&c.tls, "tls", false, "use tls for the grpc servers and clients")
+	flag.StringVar(&c.tlsCert, "tls.cert", "", "path to the pem certificate of the servers, also presented by clients to servers requiring client certificates")
+	flag.StringVar(&c.tlsKey, "tls.key", "", "path to the pem private key of tls.cert")
+	flag.StringVar(&c.tlsCA, "tls.ca", "", "path to the pem ca certificates to verify peers with, the system roots are used by clients if not set")
+	flag.BoolVar(&c.tlsClientAuth, "tls.client-auth", false, "require clients to present a certificate signed by tls.ca")
+	flag.StringVar(&c.tlsServerName, "tls.server-name", "", "server name clients verify, defaults to the host of the address dialed")
+	flag.DurationVar(&c.tlsReloadInterval, "tls.reload-interval", 10*time.Second, "how often the certificate files are checked for changes")
+	flag.IntVar(&c.retryMaxAttempts, "retry.max-attempts", 3, "attempts per unary rpc made by clients including the first one, 1 disables retries")
+	flag.DurationVar(&c.retryPerAttemptTimeout, "retry.per-attempt-timeout", 0, "timeout of every attempt, 0 only applies the deadline of the rpc")
+	flag.DurationVar(&c.retryBackoffBase, "retry.backoff.base", 50*time.Millisecond, "upper bound of the random backoff before the first retry, doubled for every further retry")
+	flag.DurationVar(&c.retryBackoffMax, "retry.backoff.max", time.Second, "max backoff between retries")
+	flag.StringVar(&c.retryCodes, "retry.codes", "Unavailable,ResourceExhausted", "comma separated grpc status codes that are retried")
+	flag.Float64Var(&c.retryBudgetTokens, "retry.budget.tokens", 10, "size of the retry budget of a connection, retries stop while fewer than half of the tokens are left, 0 disables the budget")
+	flag.Float64Var(&c.retryBudgetRatio, "retry.budget.ratio", 0.1, "tokens put back into the retry budget by every successful rpc")
+	flag.BoolVar(&c.breaker, "breaker", false, "use a circuit breaker for the calls from the pinger to randommsg")
+	flag.DurationVar(&c.breakerWindow, "breaker.window", 10*time.Second, "period over which the breaker counts failed calls")
+	flag.IntVar(&c.breakerMinRequests, "breaker.min-requests", 20, "calls needed in a window before the breaker can open")
+	flag.Float64Var(&c.breakerErrorRate, "breaker.error-rate", 0.5, "share of failed calls in a window that opens the breaker")
+	flag.DurationVar(&c.breakerLatency, "breaker.latency", 0, "calls slower than this count as failed, 0 ignores latency")
+	flag.DurationVar(&c.breakerOpenTimeout, "breaker.open-timeout", 5*time.Second, "time the breaker stays open before probing randommsg again")
+	flag.IntVar(&c.breakerHalfOpenRequests, "breaker.half-open-requests", 3, "probe calls that must succeed to close the breaker again")
+	flag.StringVar(&c.breakerFallbackMsg, "breaker.fallback-msg", "", "message the pinger answers with while the breaker is open, pings fail if not set")
+	flag.BoolVar(&c.auth, "auth", false, "require a valid bearer token on every rpc except health checks")
+	flag.StringVar(&c.authAPIKeys, "auth.api-keys", "", "path to a json object mapping identities to the api keys servers accept")
+	flag.StringVar(&c.authJWTSecretFile, "auth.jwt.secret-file", "", "path to the secret HS256 jwts are signed with, clients without auth.token sign jwts for their own identity with it")
+	flag.DurationVar(&c.authJWTTTL, "auth.jwt.ttl", 5*time.Minute, "validity of the jwts signed by clients")
+	flag.StringVar(&c.authPolicy, "auth.policy", "", "path to a json object mapping full method names to the identities allowed to call them")
+	flag.StringVar(&c.authToken, "auth.token", "", "bearer token sent by clients, an api key or a jwt")
+	flag.Float64Var(&c.limitRate, "limit.rate", 0, "rpcs per second a server admits over all clients, 0 disables the limit")
+	flag.IntVar(&c.limitBurst, "limit.burst", 100, "rpcs a server admits at once over all clients before limit.rate applies")
+	flag.Float64Var(&c.limitClientRate, "limit.client-rate", 0, "rpcs per second a server admits per client identity or address, 0 disables the limit")
+	flag.IntVar(&c.limitClientBurst, "limit.client-burst", 20, "rpcs a server admits at once per client before limit.client-rate applies")
+	flag.IntVar(&c.limitConcurrency, "limit.concurrency", 0, "rpcs a server handles concurrently per method, 0 disables the limit")
+	flag.BoolVar(&c.shed, "shed", false, "shed unary rpcs by priority once a server's latency degrades")
+	flag.IntVar(&c.shedInitialLimit, "shed.initial-limit", 20, "concurrency limit a server starts shedding from")
+	flag.IntVar(&c.shedMinLimit, "shed.min-limit", 4, "lowest concurrency limit shedding goes down to")
+	flag.IntVar(&c.shedMaxLimit, "shed.max-limit", 1000, "highest concurrency limit shedding goes up to")
+	flag.Float64Var(&c.shedTolerance, "shed.tolerance", 2, "times the short term latency may exceed the long term latency before the limit is lowered")
+	flag.StringVar(&c.priority, "priority", "normal", "priority of the rpcs sent by clients: low, normal or high")
+	flag.BoolVar(&c.faults, "faults", false, "let randommsg inject faults requested by rpc metadata and serve its fault config on /admin/faults with -admin")
+	flag.StringVar(&c.faultsConfig, "faults.config", "", "path to the json fault config of randommsg, a uniform latency of up to 200ms if not set")
+	flag.StringVar(&c.faultsRequest, "faults.request", "", "faults requested by clients, e.g. latency=300ms,error=Unavailable,reset")
+	flag.BoolVar(&c.admin, "admin", false, "serve the admin api on /admin/ of the http endpoints of the servers")
+	flag.StringVar(&c.metricsPingBuckets, "metrics.ping.buckets", "0.0005,0.001,0.0025,0.005,0.01,0.025,0.05,0.1,0.15,0.2,0.25,0.3,0.5,1,2.5,5", "comma separated buckets in seconds of the handling time histograms of the pinger and of clients calling it")
+	flag.StringVar(&c.metricsMsgBuckets, "metrics.msg.buckets", "0.001,0.005,0.01,0.025,0.05,0.075,0.1,0.125,0.15,0.175,0.2,0.25,0.5,1,2.5,5", "comma separated buckets in seconds of the handling time histograms of randommsg and of the pinger calling it")
+	flag.StringVar(&c.metricsQuantiles, "metrics.quantiles", "", "comma separated quantiles of handling time summaries, e.g. 0.5,0.9,0.99, no summaries if empty")
+	flag.StringVar(&c.pushURL, "push.url", "", "pushgateway url the client and bench push their metrics to when done, not pushed if empty")
+	flag.StringVar(&c.pushJob, "push.job", "pingpong", "job the client and bench push their metrics as")
+	flag.DurationVar(&c.pushTimeout, "push.timeout", 5*time.Second, "timeout of pushing metrics")
+	flag.StringVar(&c.configFile, "config", "", "path to a json config file, keys are flag names")
+	flag.BoolVar(&c.printConfig, "print-config", false, "print the effective configuration as json and exit")
+	flag.StringVar(&c.logLevel, "log.level", "info", "log level: debug, info, warn or error")
+	flag.StringVar(&c.logEncoding, "log.encoding", "json", "log encoding: json or console")
+	flag.StringVar(&c.samplerType, "tracing.sampler.type", "const", "jaeger sampler: const, probabilistic, ratelimiting or remote")
+	flag.Float64Var(&c.samplerParam, "tracing.sampler.param", 1, "jaeger sampler param: 0 or 1 for const, probability for probabilistic, traces per second for ratelimiting, initial probability for remote")
+	flag.StringVar(&c.samplingServerURL, "tracing.sampling.url", "http://localhost:5778/sampling", "jaeger agent sampling server url")
+	flag.StringVar(&c.agentAddr, "tracing.agent.addr", "localhost:6831", "jaeger agent address to report spans to")
+	flag.IntVar(&c.reporterQueueSize, "tracing.reporter.queue-size", 100, "spans kept in memory before the reporter starts dropping them")
+	flag.DurationVar(&c.reporterFlushInterval, "tracing.reporter.flush-interval", time.Second, "how often buffered spans are flushed to the agent")
+	flag.BoolVar(&c.logSpans, "tracing.reporter.log-spans", false, "log every reported span")
+	flag.Parse()
+
+	if c.Version {
+		fmt.Printf("Version: %v", Version)
+	}
+
+	if err := loadConfig(flag.CommandLine, c); err != nil {
+		return nil, err
+	}
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadConfig applies the config file and environment variables on top of
+// the defaults of the flags in fs, without overriding flags set on the
+// command line.
+func loadConfig(fs *flag.FlagSet, c *config) error {
+	explicit := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = f.Value.String()
+	})
+
+	path := c.configFile
+	if _, ok := explicit["config"]; !ok {
+		if env, ok := os.LookupEnv(envName("config")); ok {
+			path = env
+		}
+	}
+	if path != "" {
+		if err := loadConfigFile(fs, path); err != nil {
+			return err
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok || err != nil {
+			return
+		}
+		if setErr := f.Value.Set(value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %v: %v", value, envName(f.Name), setErr.Error())
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for name, value := range explicit {
+		if err := fs.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadConfigFile sets the flags in fs named by the keys of the json object
+// in path. Strings are unquoted, other values are passed to the flags as
+// written, so that large integers are not turned into floats.
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %v", err.Error())
+	}
+
+	values := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse config %v: %v", path, err.Error())
+	}
+
+	for name, raw := range values {
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("unknown key in config %v: %v", path, name)
+		}
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %v in config %v: %v", name, path, err.Error())
+		}
+	}
+	return nil
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(flagName))
+}
+
+// printConfig writes the effective value of every flag as a json object
+// that can be used as a config file once secrets are filled in.
+func printConfig(w io.Writer) error {
+	return writeConfig(w, configValues())
+}
+
+// secretFlags are the flags whose values are redacted when the config is
+// printed or served by the admin api.
+var secretFlags = []string{"auth.token"}
+
+// configValues returns the effective value of every flag by name, with
+// secrets redacted.
+func configValues() map[string]string {
+	values := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		switch f.Name {
+		case "config", "print-config", "version":
+			return
+		}
+		values[f.Name] = f.Value.String()
+	})
+	for _, name := range secretFlags {
+		if values[name] != "" {
+			values[name] = "redacted"
+		}
+	}
+	return values
+}
+
+func writeConfig(w io.Writer, values map[string]string) error {
+	out, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", out)
+	return err
+}
+
+func (c *config) validate() error {
+	addrs := map[string]string{
+		"grpc.ping.addr": c.grpcPingerAddr,
+		"http.ping.addr": c.httpPingerAddr,
+		"grpc.msg.addr":  c.grpcMsgAddr,
+		"http.msg.addr":  c.httpMsgAddr,
+	}
+	names := make([]string, 0, len(addrs))
+	for name := range addrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := validateAddr(addrs[name]); err != nil {
+			return fmt.Errorf("invalid %v: %v", name, err.Error())
+		}
+	}
+
+	switch c.role {
+	case "all", "pinger", "randommsg":
+	default:
+		return fmt.Errorf("invalid role: %v", c.role)
+	}
+
+	switch c.pingMode {
+	case "unary", "stream", "pingpong":
+	default:
+		return fmt.Errorf("invalid ping.mode: %v", c.pingMode)
+	}
+	if c.pingCount < 0 {
+		return fmt.Errorf("invalid ping.count: must not be negative")
+	}
+	if c.pingMode == "stream" && c.pingCount != 1 && c.pingInterval < minStreamInterval {
+		return fmt.Errorf("invalid ping.interval: must be at least %v in stream mode", minStreamInterval)
+	}
+	if c.pingSize < 0 {
+		return fmt.Errorf("invalid ping.size: must not be negative")
+	}
+	if c.pingResponseSize < 0 || c.pingResponseSize > maxResponseSize {
+		return fmt.Errorf("invalid ping.response-size: must be between 0 and %v", maxResponseSize)
+	}
+
+	if c.benchConcurrency <= 0 {
+		return fmt.Errorf("invalid bench.concurrency: must be positive")
+	}
+	if c.benchConns <= 0 {
+		return fmt.Errorf("invalid bench.conns: must be positive")
+	}
+	if c.benchRequests < 0 {
+		return fmt.Errorf("invalid bench.requests: must not be negative")
+	}
+	if c.benchRequests == 0 && c.benchDuration <= 0 {
+		return fmt.Errorf("invalid bench.duration: must be positive")
+	}
+	if c.benchQPS < 0 || c.benchQPS > maxBenchQPS {
+		return fmt.Errorf("invalid bench.qps: must be between 0 and %v", maxBenchQPS)
+	}
+
+	if c.shutdownTimeout <= 0 {
+		return fmt.Errorf("invalid shutdown.timeout: must be positive")
+	}
+
+	if c.defaultTimeout < 0 {
+		return fmt.Errorf("invalid server.default-timeout: must not be negative")
+	}
+	if c.clientTimeout < 0 {
+		return fmt.Errorf("invalid client.timeout: must not be negative")
+	}
+
+	if c.tls {
+		if (c.tlsCert == "") != (c.tlsKey == "") {
+			return fmt.Errorf("invalid tls: tls.cert and tls.key must be set together")
+		}
+		if c.server && c.tlsCert == "" {
+			return fmt.Errorf("invalid tls: servers require tls.cert and tls.key")
+		}
+		if c.tlsClientAuth && c.tlsCA == "" {
+			return fmt.Errorf("invalid tls.client-auth: requires tls.ca")
+		}
+		if c.tlsReloadInterval <= 0 {
+			return fmt.Errorf("invalid tls.reload-interval: must be positive")
+		}
+	}
+
+	if c.retryMaxAttempts < 1 {
+		return fmt.Errorf("invalid retry.max-attempts: must be at least 1")
+	}
+	if c.retryPerAttemptTimeout < 0 {
+		return fmt.Errorf("invalid retry.per-attempt-timeout: must not be negative")
+	}
+	if c.retryBackoffBase <= 0 || c.retryBackoffMax < c.retryBackoffBase {
+		return fmt.Errorf("invalid retry.backoff: base must be positive and not above max")
+	}
+	if _, err := parseCodes(c.retryCodes); err != nil {
+		return fmt.Errorf("invalid retry.codes: %v", err.Error())
+	}
+	if c.retryBudgetTokens < 0 || c.retryBudgetRatio < 0 {
+		return fmt.Errorf("invalid retry.budget: tokens and ratio must not be negative")
+	}
+
+	if c.breakerWindow <= 0 || c.breakerOpenTimeout <= 0 {
+		return fmt.Errorf("invalid breaker: window and open-timeout must be positive")
+	}
+	if c.breakerMinRequests < 1 || c.breakerHalfOpenRequests < 1 {
+		return fmt.Errorf("invalid breaker: min-requests and half-open-requests must be at least 1")
+	}
+	if c.breakerErrorRate <= 0 || c.breakerErrorRate > 1 {
+		return fmt.Errorf("invalid breaker.error-rate: must be above 0 and at most 1")
+	}
+	if c.breakerLatency < 0 {
+		return fmt.Errorf("invalid breaker.latency: must not be negative")
+	}
+
+	if c.auth && c.authAPIKeys == "" && c.authJWTSecretFile == "" {
+		return fmt.Errorf("invalid auth: requires auth.api-keys or auth.jwt.secret-file")
+	}
+	if c.authJWTTTL <= 0 {
+		return fmt.Errorf("invalid auth.jwt.ttl: must be positive")
+	}
+
+	if c.limitRate < 0 || c.limitClientRate < 0 || c.limitConcurrency < 0 {
+		return fmt.Errorf("invalid limit: rate, client-rate and concurrency must not be negative")
+	}
+	if c.limitBurst < 1 || c.limitClientBurst < 1 {
+		return fmt.Errorf("invalid limit: burst and client-burst must be at least 1")
+	}
+	if c.shedMinLimit < 1 || c.shedMinLimit > c.shedInitialLimit || c.shedInitialLimit > c.shedMaxLimit {
+		return fmt.Errorf("invalid shed: limits must satisfy 1 <= min-limit <= initial-limit <= max-limit")
+	}
+	if c.shedTolerance < 1 {
+		return fmt.Errorf("invalid shed.tolerance: must be at least 1")
+	}
+	if _, err := limit.ParsePriority(c.priority); err != nil {
+		return fmt.Errorf("invalid priority: %v", err.Error())
+	}
+	if _, err := fault.ParseRequest(c.faultsRequest); err != nil {
+		return fmt.Errorf("invalid faults.request: %v", err.Error())
+	}
+	if _, err := parseBuckets(c.metricsPingBuckets); err != nil {
+		return fmt.Errorf("invalid metrics.ping.buckets: %v", err.Error())
+	}
+	if _, err := parseBuckets(c.metricsMsgBuckets); err != nil {
+		return fmt.Errorf("invalid metrics.msg.buckets: %v", err.Error())
+	}
+	if _, err := parseQuantiles(c.metricsQuantiles); err != nil {
+		return fmt.Errorf("invalid metrics.quantiles: %v", err.Error())
+	}
+	if c.pushURL != "" {
+		u, err := url.Parse(c.pushURL)
+		if err != nil {
+			return fmt.Errorf("invalid push.url: %v", err.Error())
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return fmt.Errorf("invalid push.url: scheme must be http or https")
+		}
+		if c.pushJob == "" {
+			return fmt.Errorf("invalid push.job: must not be empty")
+		}
+		if c.pushTimeout <= 0 {
+			return fmt.Errorf("invalid push.timeout: must be positive")
+		}
+	}
+
+	switch c.samplerType {
+	case jaeger.SamplerTypeConst, jaeger.SamplerTypeRateLimiting:
+	case jaeger.SamplerTypeProbabilistic, jaeger.SamplerTypeRemote:
+		if c.samplerParam < 0 || c.samplerParam > 1 {
+			return fmt.Errorf("invalid tracing.sampler.param: %v sampler expects a probability between 0 and 1", c.samplerType)
+		}
+	default:
+		return fmt.Errorf("invalid tracing.sampler.type: %v", c.samplerType)
+	}
+	if err := validateAddr(c.agentAddr); err != nil {
+		return fmt.Errorf("invalid tracing.agent.addr: %v", err.Error())
+	}
+	if c.reporterQueueSize <= 0 {
+		return fmt.Errorf("invalid tracing.reporter.queue-size: must be positive")
+	}
+	if c.reporterFlushInterval <= 0 {
+		return fmt.Errorf("invalid tracing.reporter.flush-interval: must be positive")
+	}
+
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(c.logLevel)); err != nil {
+		return fmt.Errorf("invalid log.level: %v", err.Error())
+	}
+	switch c.logEncoding {
+	case "json", "console":
+	default:
+		return fmt.Errorf("invalid log.encoding: %v", c.logEncoding)
+	}
+	return nil
+}
+
+// parseCodes parses a comma separated list of grpc status code names, like
+// Unavailable,ResourceExhausted.
+func parseCodes(names string) ([]codes.Code, error) {
+	byName := map[string]codes.Code{}
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		byName[c.String()] = c
+	}
+
+	var parsed []codes.Code
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		c, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown code %q", name)
+		}
+		parsed = append(parsed, c)
+	}
+	return parsed, nil
+}
+
+// parseFloats parses a comma separated list of numbers.
+func parseFloats(values string) ([]float64, error) {
+	var parsed []float64
+	for _, value := range strings.Split(values, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", value)
+		}
+		parsed = append(parsed, f)
+	}
+	return parsed, nil
+}
+
+// parseBuckets parses comma separated histogram buckets, which must be
+// positive and increasing.
+func parseBuckets(values string) ([]float64, error) {
+	buckets, err := parseFloats(values)
+	if err != nil {
+		return nil, err
+	}
+	if len(buckets) == 0 {
+		return nil, fmt.Errorf("no buckets")
+	}
+	for i, b := range buckets {
+		if b <= 0 || (i > 0 && b <= buckets[i-1]) {
+			return nil, fmt.Errorf("buckets must be positive and increasing")
+		}
+	}
+	return buckets, nil
+}
+
+// parseQuantiles parses comma separated summary quantiles between 0 and 1.
+func parseQuantiles(values string) ([]float64, error) {
+	quantiles, err := parseFloats(values)
+	if err != nil {
+		return nil, err
+	}
+	for _, q := range quantiles {
+		if q <= 0 || q >= 1 {
+			return nil, fmt.Errorf("quantile %v not between 0 and 1", q)
+		}
+	}
+	return quantiles, nil
+}
+
+func validateAddr(addr string) error {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// newLogger creates the zap logger for a service from the log settings,
+// together with its level, which can be changed while the logger is in use.
+func newLogger(c *config) (*zap.Logger, zap.AtomicLevel, error) {
+	cfg := zap.NewProductionConfig()
+	if err := cfg.Level.UnmarshalText([]byte(c.logLevel)); err != nil {
+		return nil, cfg.Level, err
+	}
+	cfg.Encoding = c.logEncoding
+	logger, err := cfg.Build()
+	return logger, cfg.Level, err
+}
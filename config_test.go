@@ -0,0 +1,160 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testFlags registers a few flags of every type on a new flag set, the way
+// newServerCmd does on the command line flag set.
+func testFlags(c *config) *flag.FlagSet {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.StringVar(&c.configFile, "config", "", "")
+	fs.IntVar(&c.pingResponseSize, "ping.response-size", 0, "")
+	fs.IntVar(&c.limitBurst, "limit.burst", 100, "")
+	fs.Float64Var(&c.limitRate, "limit.rate", 0, "")
+	fs.StringVar(&c.logLevel, "log.level", "info", "")
+	fs.DurationVar(&c.clientTimeout, "client.timeout", 5*time.Second, "")
+	fs.BoolVar(&c.admin, "admin", false, "")
+	return fs
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pingpong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.json")
+	file := `{
+		"ping.response-size": 1048576,
+		"limit.burst": 2000000,
+		"limit.rate": 2.5,
+		"log.level": "warn",
+		"client.timeout": "2s",
+		"admin": true
+	}`
+	if err := ioutil.WriteFile(path, []byte(file), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		args  []string
+		env   map[string]string
+		check func(t *testing.T, c *config)
+	}{
+		{
+			name: "defaults",
+			check: func(t *testing.T, c *config) {
+				if c.limitBurst != 100 || c.logLevel != "info" || c.clientTimeout != 5*time.Second {
+					t.Errorf("got burst %v level %v timeout %v, want the defaults", c.limitBurst, c.logLevel, c.clientTimeout)
+				}
+			},
+		},
+		{
+			name: "file",
+			args: []string{"-config", path},
+			check: func(t *testing.T, c *config) {
+				if c.pingResponseSize != 1048576 {
+					t.Errorf("ping.response-size = %v, want 1048576", c.pingResponseSize)
+				}
+				if c.limitBurst != 2000000 {
+					t.Errorf("limit.burst = %v, want 2000000", c.limitBurst)
+				}
+				if c.limitRate != 2.5 {
+					t.Errorf("limit.rate = %v, want 2.5", c.limitRate)
+				}
+				if c.logLevel != "warn" || c.clientTimeout != 2*time.Second || !c.admin {
+					t.Errorf("got level %v timeout %v admin %v, want the file values", c.logLevel, c.clientTimeout, c.admin)
+				}
+			},
+		},
+		{
+			name: "file from env",
+			env:  map[string]string{"PINGPONG_CONFIG": path},
+			check: func(t *testing.T, c *config) {
+				if c.limitBurst != 2000000 {
+					t.Errorf("limit.burst = %v, want 2000000", c.limitBurst)
+				}
+			},
+		},
+		{
+			name: "env over file",
+			args: []string{"-config", path},
+			env:  map[string]string{"PINGPONG_LIMIT_BURST": "3000000", "PINGPONG_LOG_LEVEL": "debug"},
+			check: func(t *testing.T, c *config) {
+				if c.limitBurst != 3000000 || c.logLevel != "debug" {
+					t.Errorf("got burst %v level %v, want the env values", c.limitBurst, c.logLevel)
+				}
+				if c.pingResponseSize != 1048576 {
+					t.Errorf("ping.response-size = %v, want the file value", c.pingResponseSize)
+				}
+			},
+		},
+		{
+			name: "flag over env and file",
+			args: []string{"-config", path, "-limit.burst", "4000000", "-admin=false"},
+			env:  map[string]string{"PINGPONG_LIMIT_BURST": "3000000", "PINGPONG_LOG_LEVEL": "debug"},
+			check: func(t *testing.T, c *config) {
+				if c.limitBurst != 4000000 || c.admin {
+					t.Errorf("got burst %v admin %v, want the flag values", c.limitBurst, c.admin)
+				}
+				if c.logLevel != "debug" {
+					t.Errorf("log.level = %v, want the env value", c.logLevel)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, value := range tt.env {
+				os.Setenv(name, value)
+				defer os.Unsetenv(name)
+			}
+			c := new(config)
+			fs := testFlags(c)
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Parse: %v", err)
+			}
+			if err := loadConfig(fs, c); err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			tt.check(t, c)
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pingpong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "unknown key", file: `{"no.such.flag": 1}`},
+		{name: "config key", file: `{"config": "other.json"}`},
+		{name: "fraction for int", file: `{"limit.burst": 1.5}`},
+		{name: "string for bool", file: `{"admin": "maybe"}`},
+		{name: "not an object", file: `[1, 2]`},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, string(rune('a'+i))+".json")
+			if err := ioutil.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := loadConfigFile(testFlags(new(config)), path); err == nil {
+				t.Errorf("loadConfigFile succeeded, want an error")
+			}
+		})
+	}
+}
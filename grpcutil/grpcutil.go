@@ -0,0 +1,18 @@
+// Package grpcutil provides helpers shared by the services and middleware
+// for handling rpcs.
+package grpcutil
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ContextError returns the status of an rpc whose context is done, so that
+// interceptors see DeadlineExceeded or Canceled instead of Unknown.
+func ContextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	}
+	return status.Error(codes.Canceled, ctx.Err().Error())
+}
@@ -0,0 +1,33 @@
+package grpcutil
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestContextError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-expired.Done()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want codes.Code
+	}{
+		{name: "canceled", ctx: canceled, want: codes.Canceled},
+		{name: "deadline exceeded", ctx: expired, want: codes.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := grpc.Code(ContextError(tt.ctx)); code != tt.want {
+				t.Errorf("ContextError() code = %v, want %v", code, tt.want)
+			}
+		})
+	}
+}
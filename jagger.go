@@ -1,35 +1,111 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"net/http"
+	"sync/atomic"
 
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/uber/jaeger-client-go"
 	jaegercfg "github.com/uber/jaeger-client-go/config"
 )
 
-func getTracer(name string) (*opentracing.Tracer, io.Closer, error) {
-	defaultSamplingServerURL := "http://localhost:5778/sampling"
-
-	// Change config to dofferent sampler rate lile default
-	// in a production setting since not all requests need tracing
-	// only a N % is needed to take decisions about performense
-	cfg := jaegercfg.Configuration{
-		Sampler: &jaegercfg.SamplerConfig{
-			SamplingServerURL: defaultSamplingServerURL,
-			Type:              jaeger.SamplerTypeConst,
-			Param:             1,
-		},
-		Reporter: &jaegercfg.ReporterConfig{
-			LogSpans: true,
-		},
+func getTracer(name string, c *config) (*opentracing.Tracer, io.Closer, error) {
+	tracer, closer, _, err := getSwitchableTracer(name, c)
+	return tracer, closer, err
+}
+
+// getSwitchableTracer returns a tracer together with a switch that turns its
+// sampling off and on again at runtime.
+func getSwitchableTracer(name string, c *config) (*opentracing.Tracer, io.Closer, *samplingSwitch, error) {
+	// The const sampler with param 1 traces every request which is useful
+	// in development. In a production setting not all requests need tracing,
+	// only a N % is needed to take decisions about performense, so use the
+	// probabilistic, ratelimiting or remote sampler there.
+	samplerCfg := jaegercfg.SamplerConfig{
+		SamplingServerURL: c.samplingServerURL,
+		Type:              c.samplerType,
+		Param:             c.samplerParam,
+	}
+	reporterCfg := jaegercfg.ReporterConfig{
+		LocalAgentHostPort:  c.agentAddr,
+		QueueSize:           c.reporterQueueSize,
+		BufferFlushInterval: c.reporterFlushInterval,
+		LogSpans:            c.logSpans,
 	}
 
-	// Initialize tracer with a logger and a metrics factory
-	tracer, closer, err := cfg.New(name)
+	// the tracer is assembled by hand rather than with jaegercfg.New, which
+	// does not allow to wrap the sampler
+	metrics := jaeger.NewNullMetrics()
+	sampler, err := samplerCfg.NewSampler(name, metrics)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("getTracer err: %v", err.Error())
+	}
+	reporter, err := reporterCfg.NewReporter(name, metrics, jaeger.StdLogger)
 	if err != nil {
-		return nil, nil, fmt.Errorf("getTracer err: %v", err.Error())
+		sampler.Close()
+		return nil, nil, nil, fmt.Errorf("getTracer err: %v", err.Error())
+	}
+
+	sw := &samplingSwitch{Sampler: sampler, enabled: 1}
+	tracer, closer := jaeger.NewTracer(
+		name,
+		sw,
+		reporter,
+		jaeger.TracerOptions.Metrics(metrics),
+		jaeger.TracerOptions.Logger(jaeger.StdLogger),
+	)
+	return &tracer, closer, sw, nil
+}
+
+// samplingSwitch samples traces with the wrapped sampler while enabled, and
+// none at all while disabled. It serves its state as json over http and
+// takes a new one with PUT, e.g. {"enabled": false}.
+type samplingSwitch struct {
+	jaeger.Sampler
+	enabled int32
+}
+
+func (s *samplingSwitch) IsSampled(id jaeger.TraceID, operation string) (bool, []jaeger.Tag) {
+	if atomic.LoadInt32(&s.enabled) == 0 {
+		return false, nil
+	}
+	return s.Sampler.IsSampled(id, operation)
+}
+
+func (s *samplingSwitch) Equal(other jaeger.Sampler) bool {
+	if o, ok := other.(*samplingSwitch); ok {
+		return s.Sampler.Equal(o.Sampler)
+	}
+	return false
+}
+
+type samplingState struct {
+	Enabled bool `json:"enabled"`
+}
+
+func (s *samplingSwitch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var state samplingState
+		if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+			http.Error(w, fmt.Sprintf("invalid sampling state: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+		var enabled int32
+		if state.Enabled {
+			enabled = 1
+		}
+		atomic.StoreInt32(&s.enabled, enabled)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
 	}
-	return &tracer, closer, nil
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(samplingState{Enabled: atomic.LoadInt32(&s.enabled) == 1})
 }
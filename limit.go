@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/mad01/pingpong/middleware/limit"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+)
+
+// limitInterceptors returns the interceptors rate limiting, concurrency
+// limiting and shedding rpcs on a server, with their metrics registered with
+// reg. They run after auth, so that clients are rate limited by their
+// identity rather than their address.
+func limitInterceptors(c *config, reg prometheus.Registerer) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	var limiters []limit.Limiter
+	if c.limitRate > 0 || c.limitClientRate > 0 {
+		limiters = append(limiters, limit.NewRateLimiter(c.limitRate, c.limitBurst, c.limitClientRate, c.limitClientBurst, reg))
+	}
+	if c.limitConcurrency > 0 {
+		limiters = append(limiters, limit.NewConcurrencyLimiter(c.limitConcurrency, reg))
+	}
+
+	var unary []grpc.UnaryServerInterceptor
+	var stream []grpc.StreamServerInterceptor
+	for _, limiter := range limiters {
+		unary = append(unary, limit.UnaryServerInterceptor(limiter))
+		stream = append(stream, limit.StreamServerInterceptor(limiter))
+	}
+	if c.shed {
+		// streams are not shed, their latency says nothing about the load
+		adaptive := limit.NewAdaptiveLimiter(
+			reg,
+			limit.WithLimits(c.shedInitialLimit, c.shedMinLimit, c.shedMaxLimit),
+			limit.WithTolerance(c.shedTolerance),
+		)
+		unary = append(unary, limit.UnaryServerInterceptor(adaptive))
+	}
+	return unary, stream
+}
@@ -1,15 +1,30 @@
 package main
 
 import (
-	"flag"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/golang/protobuf/proto"
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
 	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
 	pb "github.com/mad01/pingpong/com"
+	"github.com/mad01/pingpong/middleware/fault"
+	"github.com/mad01/pingpong/middleware/limit"
+	"github.com/mad01/pingpong/middleware/metrics"
+	"github.com/mad01/pingpong/middleware/retry"
+	"github.com/mad01/pingpong/middleware/tracing"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 //
@@ -20,34 +35,56 @@ var (
 	appDashHTTPEndpoint = "0.0.0.0:5775"
 )
 
-type config struct {
-	server         bool
-	clinet         bool
-	msg            string
-	grpcPingerAddr string
-	httpPingerAddr string
-	httpMsgAddr    string
-	grpcMsgAddr    string
-	Version        bool
-}
+// serve runs the services selected by the role until a shutdown signal is
+// received or one of them fails, and then waits for all of them to drain. A
+// service drained over the admin api stops on its own.
+func serve(c *config) error {
+	var services []func(*config, <-chan struct{}) error
+	switch c.role {
+	case "all":
+		services = append(services, serveRandomMsgAll, servePingAll)
+	case "pinger":
+		services = append(services, servePingAll)
+	case "randommsg":
+		services = append(services, serveRandomMsgAll)
+	default:
+		return fmt.Errorf("unknown role: %v", c.role)
+	}
+
+	stop := make(chan struct{})
+	errChan := make(chan error, len(services))
+	for _, service := range services {
+		go func(service func(*config, <-chan struct{}) error) {
+			errChan <- service(c, stop)
+		}(service)
+	}
 
-func newServerCmd() *config {
-	c := new(config)
-	flag.StringVar(&c.grpcPingerAddr, "grpc.ping.addr", "0.0.0.0:8881", "grpc ping server port")
-	flag.StringVar(&c.httpPingerAddr, "http.ping.addr", "0.0.0.0:8882", "http ping server port")
-	flag.StringVar(&c.grpcMsgAddr, "grpc.msg.addr", "0.0.0.0:8883", "grpc msg server port")
-	flag.StringVar(&c.httpMsgAddr, "http.msg.addr", "0.0.0.0:8884", "http msg server port")
-	flag.BoolVar(&c.Version, "version", false, "show version")
-	flag.BoolVar(&c.server, "server", false, "run as server")
-	flag.BoolVar(&c.clinet, "client", false, "run as client")
-	flag.StringVar(&c.msg, "msg", "foobar", "message to send in ping")
-	flag.Parse()
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
 
-	if c.Version {
-		fmt.Printf("Version: %v", Version)
+	var err error
+	running := len(services)
+wait:
+	for running > 0 {
+		select {
+		case err = <-errChan:
+			running--
+			if err != nil {
+				break wait
+			}
+		case <-signalChan:
+			fmt.Println("Shutdown signal received, exiting...")
+			break wait
+		}
 	}
 
-	return c
+	close(stop)
+	for ; running > 0; running-- {
+		if serviceErr := <-errChan; serviceErr != nil && err == nil {
+			err = serviceErr
+		}
+	}
+	return err
 }
 
 //
@@ -58,32 +95,286 @@ func newServerCmd() *config {
 // Client
 //
 
-func clientGRPCconn(addr, name string) (*grpc.ClientConn, io.Closer, error) {
-	tracer, closer, err := getTracer(name)
+func clientGRPCconn(addr, name string, c *config, reg prometheus.Registerer, logger *zap.Logger, interceptors ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, io.Closer, error) {
+	tracer, closer, err := getTracer(name, c)
 	if err != nil {
 		return nil, nil, err
 	}
-	conn, err := grpc.Dial(
-		addr,
-		grpc.WithInsecure(),
-		grpc.WithUnaryInterceptor(otgrpc.OpenTracingClientInterceptor(*tracer)),
-	)
+	conn, err := dialGRPC(addr, name, *tracer, c, reg, logger, interceptors...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to connect to server: %v", err.Error())
+		closer.Close()
+		return nil, nil, err
 	}
 	return conn, closer, nil
 }
 
-func clientPing(cc *grpc.ClientConn, msg string) {
+// dialGRPC connects to addr as the client name, tracing every rpc with
+// tracer and sending it with the configured priority and faults. The
+// interceptors run early in the unary chain, client metrics are registered
+// with reg and failed certificate reloads are logged with logger. The
+// handling time of unary rpcs includes their retries, so it is the time the
+// caller waited.
+func dialGRPC(addr, name string, tracer opentracing.Tracer, c *config, reg prometheus.Registerer, logger *zap.Logger, interceptors ...grpc.UnaryClientInterceptor) (*grpc.ClientConn, error) {
+	creds, err := dialCredentials(addr, c, logger)
+	if err != nil {
+		return nil, err
+	}
+	authOpts, err := dialAuth(name, c)
+	if err != nil {
+		return nil, err
+	}
+
+	priority, _ := limit.ParsePriority(c.priority)   // validated with the config
+	faults, _ := fault.ParseRequest(c.faultsRequest) // validated with the config
+	unary := []grpc.UnaryClientInterceptor{
+		limit.UnaryClientInterceptor(priority),
+		fault.UnaryClientInterceptor(faults),
+	}
+	unary = append(unary, interceptors...)
+	clientMetrics := grpc_prometheus.NewClientMetrics()
+	clientMetrics.EnableClientHandlingTimeHistogram(grpc_prometheus.WithHistogramBuckets(clientBuckets(name, c)))
+	clientMetrics = metrics.Register(reg, clientMetrics).(*grpc_prometheus.ClientMetrics)
+	quantiles, _ := parseQuantiles(c.metricsQuantiles) // validated with the config
+	grpcMetrics := metrics.NewClientGRPCMetrics(reg, quantiles...)
+	unary = append(unary, clientMetrics.UnaryClientInterceptor(), grpcMetrics.UnaryClientInterceptor())
+	if c.retryMaxAttempts > 1 {
+		unary = append(unary, retry.UnaryClientInterceptor(retryOptions(tracer, c, reg)...))
+	}
+	unary = append(unary, otgrpc.OpenTracingClientInterceptor(tracer))
+
+	opts := []grpc.DialOption{
+		creds,
+		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(unary...)),
+		grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(
+			limit.StreamClientInterceptor(priority),
+			clientMetrics.StreamClientInterceptor(),
+			grpcMetrics.StreamClientInterceptor(),
+			tracing.StreamClientInterceptor(tracer),
+		)),
+	}
+	conn, err := grpc.Dial(addr, append(opts, authOpts...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to server: %v", err.Error())
+	}
+	return conn, nil
+}
+
+// clientBuckets returns the handling time buckets of the client name. The
+// pinger calls randommsg and gets its buckets, all other clients call the
+// pinger.
+func clientBuckets(name string, c *config) []float64 {
+	buckets := c.metricsPingBuckets
+	if name == "pinger" {
+		buckets = c.metricsMsgBuckets
+	}
+	parsed, _ := parseBuckets(buckets) // validated with the config
+	return parsed
+}
+
+// retryOptions returns the options of the retry interceptor of a connection.
+func retryOptions(tracer opentracing.Tracer, c *config, reg prometheus.Registerer) []retry.Option {
+	retryCodes, _ := parseCodes(c.retryCodes) // validated with the config
+	opts := []retry.Option{
+		retry.WithMaxAttempts(c.retryMaxAttempts),
+		retry.WithPerAttemptTimeout(c.retryPerAttemptTimeout),
+		retry.WithBackoff(c.retryBackoffBase, c.retryBackoffMax),
+		retry.WithCodes(retryCodes...),
+		retry.WithTracer(tracer),
+		retry.WithRegisterer(reg),
+	}
+	if c.retryBudgetTokens > 0 {
+		opts = append(opts, retry.WithBudget(retry.NewBudget(c.retryBudgetTokens, c.retryBudgetRatio)))
+	}
+	return opts
+}
+
+// printError prints the status code, message and details of a failed rpc.
+func printError(rpc string, err error) {
+	st, ok := status.FromError(err)
+	if !ok {
+		fmt.Printf("%v err: %v\n", rpc, err.Error())
+		return
+	}
+	fmt.Printf("%v err: code: %v message: %v\n", rpc, st.Code(), st.Message())
+	for _, detail := range st.Details() {
+		if m, ok := detail.(proto.Message); ok {
+			fmt.Printf("  %v: %v\n", proto.MessageName(m), proto.CompactTextString(m))
+		} else {
+			fmt.Printf("  %v\n", detail)
+		}
+	}
+}
+
+// printTraceID prints the trace id the server returned in trailer, to look
+// up the trace of an rpc in jaeger. Nothing is printed if the trace was not
+// sampled.
+func printTraceID(trailer metadata.MD) {
+	if ids := trailer[tracing.TraceIDTrailer]; len(ids) > 0 {
+		fmt.Printf("trace: %v\n", ids[0])
+	}
+}
+
+// newPing creates the ping with sequence number seq, stamped with the
+// current time.
+func newPing(seq uint64, c *config) *pb.PingRequest {
+	request := pb.PingRequest{
+		Msg:          c.msg,
+		Seq:          seq,
+		ResponseSize: uint32(c.pingResponseSize),
+	}
+	if c.pingSize > 0 {
+		request.Padding = make([]byte, c.pingSize)
+	}
+	request.ClientSendUnixNano = time.Now().UnixNano()
+	return &request
+}
+
+// printPong prints the round trip and one-way timings of resp, received by
+// the client at received. One-way timings assume synchronized clocks.
+func printPong(resp *pb.PongResponse, received int64) {
+	fmt.Printf(
+		"Pong: %v seq: %v size: %v rtt: %v request: %v server: %v response: %v\n",
+		resp.Msg,
+		resp.Seq,
+		len(resp.Padding),
+		time.Duration(received-resp.ClientSendUnixNano),
+		time.Duration(resp.ServerReceiveUnixNano-resp.ClientSendUnixNano),
+		time.Duration(resp.ServerSendUnixNano-resp.ServerReceiveUnixNano),
+		time.Duration(received-resp.ServerSendUnixNano),
+	)
+}
+
+func clientPing(cc *grpc.ClientConn, c *config) error {
+	client := pb.NewPingerClient(cc)
+
+	ctx := context.Background()
+	if c.clientTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.clientTimeout)
+		defer cancel()
+	}
+
+	var trailer metadata.MD
+	resp, err := client.Ping(ctx, newPing(1, c), grpc.Trailer(&trailer))
+	if err != nil {
+		printError("ping", err)
+		printTraceID(trailer)
+		return err
+	}
+	printPong(resp, time.Now().UnixNano())
+	printTraceID(trailer)
+	return nil
+}
+
+// clientPingStream requests count pongs from the server, one every interval,
+// and prints the one-way latency of each pong.
+func clientPingStream(cc *grpc.ClientConn, c *config) error {
 	client := pb.NewPingerClient(cc)
 
-	request := pb.PingRequest{Msg: msg}
-	resp, err := client.Ping(context.Background(), &request)
+	request := pb.PingStreamRequest{
+		Msg:        c.msg,
+		Count:      uint32(c.pingCount),
+		IntervalMs: uint32(c.pingInterval / time.Millisecond),
+	}
+	stream, err := client.PingStream(context.Background(), &request)
 	if err != nil {
-		fmt.Printf("ping err: %v", err.Error())
-		os.Exit(1)
+		printError("ping stream", err)
+		return err
+	}
+
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			printTraceID(stream.Trailer())
+			return nil
+		}
+		if err != nil {
+			printError("ping stream", err)
+			printTraceID(stream.Trailer())
+			return err
+		}
+		oneWay := time.Duration(time.Now().UnixNano() - resp.ServerSendUnixNano)
+		fmt.Printf("Pong: %v one-way: %v\n", resp.Msg, oneWay)
 	}
-	fmt.Printf("Pong: %v", resp.Msg)
+}
+
+// clientPingPong sends count pings over a single bidi stream, one every
+// interval, and prints the timings of each pong. Pongs that do not answer
+// the last ping sent are reported as out of order.
+func clientPingPong(cc *grpc.ClientConn, c *config) error {
+	client := pb.NewPingerClient(cc)
+
+	stream, err := client.PingPong(context.Background())
+	if err != nil {
+		printError("ping pong", err)
+		return err
+	}
+
+	for i := 0; i < c.pingCount; i++ {
+		if i > 0 {
+			time.Sleep(c.pingInterval)
+		}
+
+		seq := uint64(i + 1)
+		if err := stream.Send(newPing(seq, c)); err != nil {
+			printError("ping pong", err)
+			return err
+		}
+		resp, err := stream.Recv()
+		if err != nil {
+			printError("ping pong", err)
+			printTraceID(stream.Trailer())
+			return err
+		}
+		printPong(resp, time.Now().UnixNano())
+		if resp.Seq != seq {
+			fmt.Printf("out of order: expected seq %v got %v\n", seq, resp.Seq)
+		}
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		printError("ping pong", err)
+		return err
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		printError("ping pong", err)
+		printTraceID(stream.Trailer())
+		return err
+	}
+	printTraceID(stream.Trailer())
+	return nil
+}
+
+// runClient connects to the pinger and pings it in the configured mode.
+// Failures are printed, and the client metrics are pushed if push.url is
+// set.
+func runClient(c *config) error {
+	logger, _, err := newLogger(c)
+	if err != nil {
+		fmt.Printf("%s \n", err)
+		return err
+	}
+	defer logger.Sync()
+
+	registry := prometheus.NewRegistry()
+	// push also the metrics of failed pings
+	defer pushMetrics(c, "cli", registry, logger)
+
+	cc, closer, err := clientGRPCconn(c.grpcPingerAddr, "cli", c, registry, logger)
+	if err != nil {
+		fmt.Printf("Fail connect to server: %v\n", err.Error())
+		return err
+	}
+	defer closer.Close()
+	defer cc.Close()
+
+	switch c.pingMode {
+	case "stream":
+		return clientPingStream(cc, c)
+	case "pingpong":
+		return clientPingPong(cc, c)
+	}
+	return clientPing(cc, c)
 }
 
 //
@@ -91,21 +382,38 @@ func clientPing(cc *grpc.ClientConn, msg string) {
 //
 
 func main() {
-	conf := newServerCmd()
+	conf, err := newServerCmd()
+	if err != nil {
+		fmt.Printf("%s \n", err)
+		os.Exit(2)
+	}
+	if conf.printConfig {
+		if err := printConfig(os.Stdout); err != nil {
+			fmt.Printf("%s \n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	if conf.server {
-		go serveRandomMsgAll(conf)
-		servePingAll(conf)
+		if err := serve(conf); err != nil {
+			fmt.Printf("%s \n", err)
+			os.Exit(1)
+		}
+	}
+
+	if conf.bench {
+		if err := bench(conf); err != nil {
+			fmt.Printf("%s \n", err)
+			os.Exit(1)
+		}
+		return
 	}
 
 	if conf.clinet {
-		cc, closer, err := clientGRPCconn(conf.grpcPingerAddr, "cli")
-		defer cc.Close()
-		defer closer.Close()
-		if err != nil {
-			fmt.Printf("Fail connect to server: %v", err.Error())
+		if err := runClient(conf); err != nil {
 			os.Exit(1)
 		}
-		clientPing(cc, conf.msg)
 	}
 
 }
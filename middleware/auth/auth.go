@@ -0,0 +1,181 @@
+// Package auth provides interceptors authenticating rpcs by the bearer token
+// in their metadata, either a static api key or a HMAC signed jwt, and
+// authorizing the authenticated identity per method.
+package auth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// publicPrefix is the prefix of the methods that are callable without a
+// token, so that load balancers can check health without credentials.
+const publicPrefix = "/grpc.health.v1.Health/"
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity authenticated for the rpc.
+func IdentityFromContext(ctx context.Context) (string, bool) {
+	identity, ok := ctx.Value(identityKey{}).(string)
+	return identity, ok
+}
+
+// Authenticator authenticates bearer tokens and checks that the identity is
+// allowed to call the method.
+type Authenticator struct {
+	// apiKeys maps identities to their api key
+	apiKeys   map[string]string
+	jwtSecret []byte
+	// policy maps full method names to the identities allowed to call them,
+	// methods that are not in the policy can be called by any identity
+	policy map[string][]string
+}
+
+// NewAuthenticator creates an authenticator accepting the api keys and the
+// jwts signed with jwtSecret. Api keys or jwts are not accepted when
+// apiKeys or jwtSecret are empty.
+func NewAuthenticator(apiKeys map[string]string, jwtSecret []byte, policy map[string][]string) *Authenticator {
+	return &Authenticator{
+		apiKeys:   apiKeys,
+		jwtSecret: jwtSecret,
+		policy:    policy,
+	}
+}
+
+// LoadAPIKeys reads a json object mapping identities to api keys from path.
+func LoadAPIKeys(path string) (map[string]string, error) {
+	apiKeys := map[string]string{}
+	if err := loadJSON(path, &apiKeys); err != nil {
+		return nil, err
+	}
+	for identity, key := range apiKeys {
+		if key == "" {
+			return nil, fmt.Errorf("empty api key for %v in %v", identity, path)
+		}
+	}
+	return apiKeys, nil
+}
+
+// LoadPolicy reads a json object mapping full method names, e.g.
+// /com.RandomMsg/GetRandomMsg, to the identities allowed to call them from
+// path.
+func LoadPolicy(path string) (map[string][]string, error) {
+	policy := map[string][]string{}
+	if err := loadJSON(path, &policy); err != nil {
+		return nil, err
+	}
+	for method := range policy {
+		if !strings.HasPrefix(method, "/") || strings.Count(method, "/") != 2 {
+			return nil, fmt.Errorf("invalid method %v in %v", method, path)
+		}
+	}
+	return policy, nil
+}
+
+func loadJSON(path string, v interface{}) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %v: %v", path, err.Error())
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %v: %v", path, err.Error())
+	}
+	return nil
+}
+
+// Authenticate returns a context carrying the identity of the token in the
+// metadata of ctx, or an Unauthenticated or PermissionDenied error.
+func (a *Authenticator) Authenticate(ctx context.Context, fullMethod string) (context.Context, error) {
+	if strings.HasPrefix(fullMethod, publicPrefix) {
+		return ctx, nil
+	}
+
+	token, err := bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	identity, err := a.identity(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if allowed, ok := a.policy[fullMethod]; ok && !contains(allowed, identity) {
+		return nil, status.Errorf(codes.PermissionDenied, "%v is not allowed to call %v", identity, fullMethod)
+	}
+
+	grpc_ctxtags.Extract(ctx).Set("auth.identity", identity)
+	return context.WithValue(ctx, identityKey{}, identity), nil
+}
+
+func (a *Authenticator) identity(token string) (string, error) {
+	for identity, key := range a.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+			return identity, nil
+		}
+	}
+
+	if len(a.jwtSecret) > 0 && strings.Count(token, ".") == 2 {
+		subject, err := parseJWT(token, a.jwtSecret)
+		if err != nil {
+			return "", status.Errorf(codes.Unauthenticated, "invalid token: %v", err.Error())
+		}
+		return subject, nil
+	}
+	return "", status.Errorf(codes.Unauthenticated, "invalid token")
+}
+
+func bearerToken(ctx context.Context) (string, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md["authorization"]) == 0 {
+		return "", status.Errorf(codes.Unauthenticated, "missing authorization token")
+	}
+	parts := strings.SplitN(md["authorization"][0], " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return "", status.Errorf(codes.Unauthenticated, "authorization is not a bearer token")
+	}
+	return parts[1], nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// UnaryServerInterceptor rejects unary rpcs that fail authentication.
+func UnaryServerInterceptor(a *Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := a.Authenticate(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams that fail authentication.
+func StreamServerInterceptor(a *Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := a.Authenticate(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = ctx
+		return handler(srv, wrapped)
+	}
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// testServerStream is a server stream with the given context.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context { return s.ctx }
+
+func withAuthorization(values ...string) context.Context {
+	md := metadata.MD{}
+	if len(values) > 0 {
+		md["authorization"] = values
+	}
+	return metadata.NewIncomingContext(context.Background(), md)
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	hour := int64(time.Hour / time.Second)
+	a := NewAuthenticator(
+		map[string]string{"monitoring": "api-key"},
+		secret,
+		map[string][]string{"/com.RandomMsg/GetRandomMsg": {"pinger"}},
+	)
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		code     codes.Code
+		identity string
+	}{
+		{
+			name:   "missing metadata",
+			ctx:    context.Background(),
+			method: "/com.Pinger/Ping",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "missing header",
+			ctx:    withAuthorization(),
+			method: "/com.Pinger/Ping",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "basic scheme",
+			ctx:    withAuthorization("Basic api-key"),
+			method: "/com.Pinger/Ping",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "no scheme",
+			ctx:    withAuthorization("api-key"),
+			method: "/com.Pinger/Ping",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:     "api key",
+			ctx:      withAuthorization("Bearer api-key"),
+			method:   "/com.Pinger/Ping",
+			code:     codes.OK,
+			identity: "monitoring",
+		},
+		{
+			name:   "wrong api key",
+			ctx:    withAuthorization("Bearer other-key"),
+			method: "/com.Pinger/Ping",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:     "jwt",
+			ctx:      withAuthorization("bearer " + testJWT(t, jwtClaims{Subject: "cli", ExpiresAt: now.Unix() + hour}, secret)),
+			method:   "/com.Pinger/Ping",
+			code:     codes.OK,
+			identity: "cli",
+		},
+		{
+			name:   "expired jwt",
+			ctx:    withAuthorization("Bearer " + testJWT(t, jwtClaims{Subject: "cli", ExpiresAt: now.Unix() - hour}, secret)),
+			method: "/com.Pinger/Ping",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "jwt signed with wrong key",
+			ctx:    withAuthorization("Bearer " + testJWT(t, jwtClaims{Subject: "cli", ExpiresAt: now.Unix() + hour}, []byte("other secret"))),
+			method: "/com.Pinger/Ping",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "denied by policy",
+			ctx:    withAuthorization("Bearer api-key"),
+			method: "/com.RandomMsg/GetRandomMsg",
+			code:   codes.PermissionDenied,
+		},
+		{
+			name:     "allowed by policy",
+			ctx:      withAuthorization("Bearer " + testJWT(t, jwtClaims{Subject: "pinger", ExpiresAt: now.Unix() + hour}, secret)),
+			method:   "/com.RandomMsg/GetRandomMsg",
+			code:     codes.OK,
+			identity: "pinger",
+		},
+		{
+			name:   "public health check",
+			ctx:    context.Background(),
+			method: "/grpc.health.v1.Health/Check",
+			code:   codes.OK,
+		},
+		{
+			name:   "public prefix only",
+			ctx:    context.Background(),
+			method: "/com.Pinger/grpc.health.v1.Health/Check",
+			code:   codes.Unauthenticated,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				identity, _ := IdentityFromContext(ctx)
+				if identity != tc.identity {
+					t.Errorf("got identity %q, want %q", identity, tc.identity)
+				}
+				return nil, nil
+			}
+
+			_, err := UnaryServerInterceptor(a)(tc.ctx, nil, &grpc.UnaryServerInfo{FullMethod: tc.method}, handler)
+			if grpc.Code(err) != tc.code {
+				t.Fatalf("got code %v, want %v", grpc.Code(err), tc.code)
+			}
+			if called != (tc.code == codes.OK) {
+				t.Errorf("handler called %v, want %v", called, tc.code == codes.OK)
+			}
+		})
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	secret := []byte("secret")
+	a := NewAuthenticator(nil, secret, nil)
+	token, err := SignJWT("cli", secret, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		method   string
+		code     codes.Code
+		identity string
+	}{
+		{
+			name:     "jwt",
+			ctx:      withAuthorization("Bearer " + token),
+			method:   "/com.Pinger/PingStream",
+			code:     codes.OK,
+			identity: "cli",
+		},
+		{
+			name:   "missing header",
+			ctx:    withAuthorization(),
+			method: "/com.Pinger/PingStream",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "api keys disabled",
+			ctx:    withAuthorization("Bearer api-key"),
+			method: "/com.Pinger/PingStream",
+			code:   codes.Unauthenticated,
+		},
+		{
+			name:   "public health watch",
+			ctx:    context.Background(),
+			method: "/grpc.health.v1.Health/Watch",
+			code:   codes.OK,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			called := false
+			handler := func(srv interface{}, stream grpc.ServerStream) error {
+				called = true
+				identity, _ := IdentityFromContext(stream.Context())
+				if identity != tc.identity {
+					t.Errorf("got identity %q, want %q", identity, tc.identity)
+				}
+				return nil
+			}
+
+			ss := &testServerStream{ctx: tc.ctx}
+			err := StreamServerInterceptor(a)(nil, ss, &grpc.StreamServerInfo{FullMethod: tc.method}, handler)
+			if grpc.Code(err) != tc.code {
+				t.Fatalf("got code %v, want %v", grpc.Code(err), tc.code)
+			}
+			if called != (tc.code == codes.OK) {
+				t.Errorf("handler called %v, want %v", called, tc.code == codes.OK)
+			}
+		})
+	}
+}
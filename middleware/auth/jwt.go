@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// only HS256 signed jwts are supported, the header of every token is the same
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	IssuedAt  int64  `json:"iat,omitempty"`
+	NotBefore int64  `json:"nbf,omitempty"`
+	ExpiresAt int64  `json:"exp,omitempty"`
+}
+
+// SignJWT returns a HS256 jwt for subject signed with secret that expires
+// after ttl.
+func SignJWT(subject string, secret []byte, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims, err := json.Marshal(jwtClaims{
+		Subject:   subject,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sign(unsigned, secret)), nil
+}
+
+// parseJWT verifies the signature and validity period of a HS256 jwt and
+// returns its subject. Tokens without an expiry are rejected, so that a
+// leaked token can't be used forever.
+func parseJWT(token string, secret []byte) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed jwt")
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwt header")
+	}
+	var h struct {
+		Alg string `json:"alg"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return "", fmt.Errorf("malformed jwt header")
+	}
+	if h.Alg != "HS256" {
+		return "", fmt.Errorf("unsupported jwt alg %q", h.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(signature, sign(parts[0]+"."+parts[1], secret)) {
+		return "", fmt.Errorf("invalid jwt signature")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", fmt.Errorf("malformed jwt claims")
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return "", fmt.Errorf("malformed jwt claims")
+	}
+
+	now := time.Now().Unix()
+	if claims.ExpiresAt == 0 {
+		return "", fmt.Errorf("jwt has no expiry")
+	}
+	if now >= claims.ExpiresAt {
+		return "", fmt.Errorf("jwt expired")
+	}
+	if claims.NotBefore != 0 && now < claims.NotBefore {
+		return "", fmt.Errorf("jwt not valid yet")
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("jwt has no subject")
+	}
+	return claims.Subject, nil
+}
+
+func sign(unsigned string, secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(unsigned))
+	return mac.Sum(nil)
+}
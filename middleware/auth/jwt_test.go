@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// testJWT signs claims with secret without setting any claims itself.
+func testJWT(t *testing.T, claims jwtClaims, secret []byte) string {
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sign(unsigned, secret))
+}
+
+func TestParseJWT(t *testing.T) {
+	secret := []byte("secret")
+	now := time.Now()
+	hour := int64(time.Hour / time.Second)
+
+	tests := []struct {
+		name    string
+		token   string
+		subject string
+		wantErr bool
+	}{
+		{
+			name:    "valid",
+			token:   testJWT(t, jwtClaims{Subject: "cli", ExpiresAt: now.Unix() + hour}, secret),
+			subject: "cli",
+		},
+		{
+			name:    "valid after not before",
+			token:   testJWT(t, jwtClaims{Subject: "cli", NotBefore: now.Unix() - hour, ExpiresAt: now.Unix() + hour}, secret),
+			subject: "cli",
+		},
+		{
+			name:    "expired",
+			token:   testJWT(t, jwtClaims{Subject: "cli", ExpiresAt: now.Unix() - hour}, secret),
+			wantErr: true,
+		},
+		{
+			name:    "not valid yet",
+			token:   testJWT(t, jwtClaims{Subject: "cli", NotBefore: now.Unix() + hour, ExpiresAt: now.Unix() + 2*hour}, secret),
+			wantErr: true,
+		},
+		{
+			name:    "missing exp",
+			token:   testJWT(t, jwtClaims{Subject: "cli", IssuedAt: now.Unix()}, secret),
+			wantErr: true,
+		},
+		{
+			name:    "bad signature",
+			token:   testJWT(t, jwtClaims{Subject: "cli", ExpiresAt: now.Unix() + hour}, []byte("other secret")),
+			wantErr: true,
+		},
+		{
+			name:    "missing subject",
+			token:   testJWT(t, jwtClaims{ExpiresAt: now.Unix() + hour}, secret),
+			wantErr: true,
+		},
+		{
+			name:    "other alg",
+			token:   base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + ".e30.",
+			wantErr: true,
+		},
+		{
+			name:    "malformed",
+			token:   "not-a-jwt",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := parseJWT(tt.token, secret)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseJWT succeeded with subject %q, want an error", subject)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseJWT: %v", err)
+			}
+			if subject != tt.subject {
+				t.Errorf("subject = %q, want %q", subject, tt.subject)
+			}
+		})
+	}
+}
+
+func TestSignJWT(t *testing.T) {
+	secret := []byte("secret")
+	token, err := SignJWT("pinger", secret, time.Minute)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+	subject, err := parseJWT(token, secret)
+	if err != nil {
+		t.Fatalf("parseJWT: %v", err)
+	}
+	if subject != "pinger" {
+		t.Errorf("subject = %q, want pinger", subject)
+	}
+
+	expired, err := SignJWT("pinger", secret, -time.Minute)
+	if err != nil {
+		t.Fatalf("SignJWT: %v", err)
+	}
+	if _, err := parseJWT(expired, secret); err == nil {
+		t.Errorf("parseJWT accepted a jwt signed with a negative ttl")
+	}
+}
@@ -0,0 +1,334 @@
+// Package breaker provides a circuit breaker for the rpcs to a dependency,
+// which fails calls fast while the dependency keeps failing or is too slow.
+package breaker
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mad01/pingpong/middleware/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrOpen is returned for calls rejected by an open breaker.
+var ErrOpen = status.Error(codes.Unavailable, "circuit breaker is open")
+
+// State is the state of a circuit breaker.
+type State int
+
+const (
+	// Closed lets all calls through while counting failures.
+	Closed State = iota
+	// Open fails all calls until the open timeout expired.
+	Open
+	// HalfOpen lets a few probe calls through to decide whether to close or
+	// open again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+type options struct {
+	window           time.Duration
+	minRequests      int
+	errorRate        float64
+	latency          time.Duration
+	openTimeout      time.Duration
+	halfOpenRequests int
+	logger           *zap.Logger
+	registerer       prometheus.Registerer
+}
+
+func defaultOptions() options {
+	return options{
+		window:           10 * time.Second,
+		minRequests:      20,
+		errorRate:        0.5,
+		openTimeout:      5 * time.Second,
+		halfOpenRequests: 3,
+		logger:           zap.NewNop(),
+		registerer:       prometheus.NewRegistry(),
+	}
+}
+
+// Option configures a Breaker.
+type Option func(*options)
+
+// WithWindow sets the period over which failures are counted while closed.
+func WithWindow(window time.Duration) Option {
+	return func(o *options) {
+		o.window = window
+	}
+}
+
+// WithErrorRate sets the share of failed calls in a window at which the
+// breaker opens, once at least minRequests calls were made in the window.
+func WithErrorRate(rate float64, minRequests int) Option {
+	return func(o *options) {
+		o.errorRate = rate
+		o.minRequests = minRequests
+	}
+}
+
+// WithLatency counts calls slower than latency as failures. Latency is not
+// considered by default.
+func WithLatency(latency time.Duration) Option {
+	return func(o *options) {
+		o.latency = latency
+	}
+}
+
+// WithOpenTimeout sets how long the breaker stays open before letting probe
+// calls through.
+func WithOpenTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.openTimeout = timeout
+	}
+}
+
+// WithHalfOpenRequests sets the number of probe calls that must succeed in a
+// row to close the breaker again.
+func WithHalfOpenRequests(n int) Option {
+	return func(o *options) {
+		o.halfOpenRequests = n
+	}
+}
+
+// WithLogger sets the logger state transitions are logged with.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithRegisterer sets the registry the breaker metrics are registered with.
+// By default they are registered with a private registry and not exported.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registerer = reg
+	}
+}
+
+// Breaker is a circuit breaker with closed, open and half-open states.
+type Breaker struct {
+	name string
+	opts options
+
+	stateGauge       *prometheus.GaugeVec
+	transitionsTotal *prometheus.CounterVec
+
+	mu          sync.Mutex
+	state       State
+	windowStart time.Time
+	requests    int
+	failures    int
+	openedAt    time.Time
+	probes      int
+	successes   int
+
+	// generation counts the transitions, so that results of calls admitted
+	// in an earlier state can be told apart
+	generation uint64
+}
+
+// New creates a closed breaker. The name labels its metrics and logs.
+func New(name string, opts ...Option) *Breaker {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	b := &Breaker{
+		name: name,
+		opts: o,
+		stateGauge: metrics.NewGaugeVec(o.registerer, prometheus.GaugeOpts{
+			Namespace: "grpc",
+			Subsystem: "client",
+			Name:      "circuit_breaker_state",
+			Help:      "State of the circuit breaker: 0 closed, 1 open, 2 half-open.",
+		}, []string{"breaker"}),
+		transitionsTotal: metrics.NewCounterVec(o.registerer, prometheus.CounterOpts{
+			Namespace: "grpc",
+			Subsystem: "client",
+			Name:      "circuit_breaker_transitions_total",
+			Help:      "Total number of circuit breaker state transitions.",
+		}, []string{"breaker", "from", "to"}),
+		windowStart: time.Now(),
+	}
+	b.stateGauge.WithLabelValues(name).Set(float64(Closed))
+	return b
+}
+
+// State returns the current state of the breaker.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expire(time.Now())
+	return b.state
+}
+
+// allow reports whether a call may be made now, and the generation of the
+// state the call is admitted in, to be passed to done.
+func (b *Breaker) allow() (uint64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.expire(time.Now())
+	switch b.state {
+	case Open:
+		return 0, false
+	case HalfOpen:
+		if b.probes >= b.opts.halfOpenRequests {
+			return 0, false
+		}
+		b.probes++
+	}
+	return b.generation, true
+}
+
+// outcome is the result of a call as seen by the breaker.
+type outcome int
+
+const (
+	success outcome = iota
+	failure
+	// ignored calls, like calls canceled or timed out by the caller, say
+	// nothing about the dependency. They release their probe without
+	// counting.
+	ignored
+)
+
+// done records the outcome of a call allowed by allow in generation. Calls
+// admitted before the last transition are ignored, so that a call admitted
+// while closed doesn't count as a probe once half-open.
+func (b *Breaker) done(generation uint64, result outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.expire(now)
+	if generation != b.generation {
+		return
+	}
+	switch b.state {
+	case Closed:
+		if result == ignored {
+			return
+		}
+		b.requests++
+		if result == failure {
+			b.failures++
+		}
+		if b.requests >= b.opts.minRequests && float64(b.failures)/float64(b.requests) >= b.opts.errorRate {
+			b.transition(Open, now)
+		}
+	case HalfOpen:
+		switch result {
+		case ignored:
+			if b.probes > 0 {
+				b.probes--
+			}
+			return
+		case failure:
+			b.transition(Open, now)
+			return
+		}
+		b.successes++
+		if b.successes >= b.opts.halfOpenRequests {
+			b.transition(Closed, now)
+		}
+	}
+}
+
+// expire starts a new window while closed and moves to half-open once the
+// open timeout expired. It must be called with mu held.
+func (b *Breaker) expire(now time.Time) {
+	switch b.state {
+	case Closed:
+		if now.Sub(b.windowStart) >= b.opts.window {
+			b.windowStart = now
+			b.requests = 0
+			b.failures = 0
+		}
+	case Open:
+		if now.Sub(b.openedAt) >= b.opts.openTimeout {
+			b.transition(HalfOpen, now)
+		}
+	}
+}
+
+// transition moves the breaker to state. It must be called with mu held.
+func (b *Breaker) transition(state State, now time.Time) {
+	from := b.state
+	b.state = state
+	b.generation++
+	switch state {
+	case Closed:
+		b.windowStart = now
+		b.requests = 0
+		b.failures = 0
+	case Open:
+		b.openedAt = now
+	case HalfOpen:
+		b.probes = 0
+		b.successes = 0
+	}
+
+	b.stateGauge.WithLabelValues(b.name).Set(float64(state))
+	b.transitionsTotal.WithLabelValues(b.name, from.String(), state.String()).Inc()
+	b.opts.logger.Info(
+		"circuit breaker state changed",
+		zap.String("breaker", b.name),
+		zap.String("from", from.String()),
+		zap.String("to", state.String()),
+	)
+}
+
+// classify returns the outcome of a call that returned code after latency.
+func (b *Breaker) classify(code codes.Code, latency time.Duration) outcome {
+	if b.opts.latency > 0 && latency > b.opts.latency {
+		return failure
+	}
+	switch code {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Internal, codes.Unknown:
+		return failure
+	}
+	return success
+}
+
+// UnaryClientInterceptor fails calls with ErrOpen while the breaker is open
+// and records the outcome of the others. It is meant to run before the retry
+// interceptor, so that a retried call counts once.
+func (b *Breaker) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		generation, ok := b.allow()
+		if !ok {
+			return ErrOpen
+		}
+		start := time.Now()
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		code := grpc.Code(err)
+		if ctx.Err() != nil && (code == codes.Canceled || code == codes.DeadlineExceeded) {
+			// the caller gave up, the dependency may be fine
+			b.done(generation, ignored)
+			return err
+		}
+		b.done(generation, b.classify(code, time.Since(start)))
+		return err
+	}
+}
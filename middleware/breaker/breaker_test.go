@@ -0,0 +1,233 @@
+package breaker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const openTimeout = 20 * time.Millisecond
+
+func newTestBreaker(opts ...Option) *Breaker {
+	return New("test", append([]Option{
+		WithWindow(time.Minute),
+		WithErrorRate(0.5, 4),
+		WithOpenTimeout(openTimeout),
+		WithHalfOpenRequests(2),
+		WithRegisterer(prometheus.NewRegistry()),
+	}, opts...)...)
+}
+
+// call makes a call through the breaker's interceptor that returns err.
+func call(b *Breaker, ctx context.Context, err error) error {
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		return err
+	}
+	return b.UnaryClientInterceptor()(ctx, "/com.RandomMsg/GetRandomMsg", nil, nil, nil, invoker)
+}
+
+var unavailable = status.Error(codes.Unavailable, "unavailable")
+
+func TestTransitions(t *testing.T) {
+	b := newTestBreaker()
+	ctx := context.Background()
+
+	// closed until min requests are reached
+	for i := 0; i < 3; i++ {
+		call(b, ctx, unavailable)
+	}
+	if state := b.State(); state != Closed {
+		t.Fatalf("state after 3 failures = %v, want closed", state)
+	}
+
+	call(b, ctx, unavailable)
+	if state := b.State(); state != Open {
+		t.Fatalf("state after 4 failures = %v, want open", state)
+	}
+	if err := call(b, ctx, nil); err != ErrOpen {
+		t.Fatalf("call while open = %v, want ErrOpen", err)
+	}
+
+	time.Sleep(openTimeout)
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("state after open timeout = %v, want half-open", state)
+	}
+
+	if err := call(b, ctx, nil); err != nil {
+		t.Fatalf("probe = %v, want nil", err)
+	}
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("state after 1 of 2 probes = %v, want half-open", state)
+	}
+	call(b, ctx, nil)
+	if state := b.State(); state != Closed {
+		t.Fatalf("state after 2 successful probes = %v, want closed", state)
+	}
+}
+
+func TestHalfOpenFailureReopens(t *testing.T) {
+	b := newTestBreaker()
+	ctx := context.Background()
+	for i := 0; i < 4; i++ {
+		call(b, ctx, unavailable)
+	}
+	time.Sleep(openTimeout)
+
+	call(b, ctx, nil)
+	call(b, ctx, unavailable)
+	if state := b.State(); state != Open {
+		t.Fatalf("state after failed probe = %v, want open", state)
+	}
+}
+
+func TestCanceledProbe(t *testing.T) {
+	b := newTestBreaker()
+	for i := 0; i < 4; i++ {
+		call(b, context.Background(), unavailable)
+	}
+	time.Sleep(openTimeout)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < 5; i++ {
+		call(b, canceled, status.Error(codes.Canceled, "canceled"))
+	}
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("state after canceled probes = %v, want half-open", state)
+	}
+
+	// the canceled probes released their slots
+	for i := 0; i < 2; i++ {
+		if err := call(b, context.Background(), nil); err != nil {
+			t.Fatalf("probe %v = %v, want nil", i, err)
+		}
+	}
+	if state := b.State(); state != Closed {
+		t.Fatalf("state after successful probes = %v, want closed", state)
+	}
+}
+
+func TestCallerDeadline(t *testing.T) {
+	b := newTestBreaker()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	deadlineExceeded := status.Error(codes.DeadlineExceeded, "deadline exceeded")
+	for i := 0; i < 4; i++ {
+		call(b, expired, deadlineExceeded)
+	}
+	if state := b.State(); state != Closed {
+		t.Fatalf("state after calls past the caller's deadline = %v, want closed", state)
+	}
+
+	// a deadline exceeded downstream, with time left for the caller, is a
+	// failure
+	for i := 0; i < 4; i++ {
+		call(b, context.Background(), deadlineExceeded)
+	}
+	if state := b.State(); state != Open {
+		t.Fatalf("state after downstream deadlines = %v, want open", state)
+	}
+}
+
+func TestStaleResultIgnored(t *testing.T) {
+	b := newTestBreaker(WithHalfOpenRequests(1))
+
+	// a slow call admitted while closed
+	generation, ok := b.allow()
+	if !ok {
+		t.Fatalf("call not allowed while closed")
+	}
+	for i := 0; i < 4; i++ {
+		call(b, context.Background(), unavailable)
+	}
+	time.Sleep(openTimeout)
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("state = %v, want half-open", state)
+	}
+
+	// finishing during half-open it must not close the breaker
+	b.done(generation, success)
+	if state := b.State(); state != HalfOpen {
+		t.Fatalf("state after a stale success = %v, want half-open", state)
+	}
+	if err := call(b, context.Background(), nil); err != nil {
+		t.Fatalf("probe = %v, want nil as the stale call took no probe slot", err)
+	}
+	if state := b.State(); state != Closed {
+		t.Fatalf("state after the probe = %v, want closed", state)
+	}
+}
+
+func TestHalfOpenLimitsProbes(t *testing.T) {
+	b := newTestBreaker(WithHalfOpenRequests(1))
+	for i := 0; i < 4; i++ {
+		call(b, context.Background(), unavailable)
+	}
+	time.Sleep(openTimeout)
+
+	generation, ok := b.allow()
+	if !ok {
+		t.Fatalf("first probe not allowed")
+	}
+	if _, ok := b.allow(); ok {
+		t.Fatalf("second concurrent probe allowed")
+	}
+	b.done(generation, success)
+	if state := b.State(); state != Closed {
+		t.Fatalf("state = %v, want closed", state)
+	}
+}
+
+func TestClassify(t *testing.T) {
+	b := newTestBreaker(WithLatency(100 * time.Millisecond))
+	tests := []struct {
+		code    codes.Code
+		latency time.Duration
+		want    outcome
+	}{
+		{code: codes.OK, want: success},
+		{code: codes.NotFound, want: success},
+		{code: codes.InvalidArgument, want: success},
+		{code: codes.Unavailable, want: failure},
+		{code: codes.DeadlineExceeded, want: failure},
+		{code: codes.ResourceExhausted, want: failure},
+		{code: codes.Internal, want: failure},
+		{code: codes.Unknown, want: failure},
+		{code: codes.OK, latency: time.Second, want: failure},
+	}
+	for _, tt := range tests {
+		if got := b.classify(tt.code, tt.latency); got != tt.want {
+			t.Errorf("classify(%v, %v) = %v, want %v", tt.code, tt.latency, got, tt.want)
+		}
+	}
+}
+
+func TestWindowResets(t *testing.T) {
+	b := newTestBreaker(WithWindow(20 * time.Millisecond))
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		call(b, ctx, unavailable)
+	}
+	time.Sleep(20 * time.Millisecond)
+	call(b, ctx, unavailable)
+	if state := b.State(); state != Closed {
+		t.Fatalf("state = %v, want closed as the failures were in different windows", state)
+	}
+}
+
+func TestDefaultRegisterer(t *testing.T) {
+	New("test")
+
+	// registering the same metric with the global registry fails if the
+	// breaker registered it there
+	state := prometheus.NewGauge(prometheus.GaugeOpts{Name: "grpc_client_circuit_breaker_state", Help: "test"})
+	if err := prometheus.Register(state); err != nil {
+		t.Fatalf("breaker metrics registered with the global registry: %v", err)
+	}
+	prometheus.Unregister(state)
+}
@@ -0,0 +1,65 @@
+// Package deadline provides interceptors applying a default timeout to rpcs
+// whose caller sent no deadline and recording the deadline budget left when
+// an rpc reaches the server.
+package deadline
+
+import (
+	"path"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/mad01/pingpong/middleware/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+func newRemainingSeconds(reg prometheus.Registerer) *prometheus.HistogramVec {
+	return metrics.NewHistogramVec(reg, prometheus.HistogramOpts{
+		Namespace: "grpc",
+		Subsystem: "server",
+		Name:      "deadline_remaining_seconds",
+		Help:      "Deadline budget left when the rpc reached the server.",
+		Buckets:   []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"grpc_service", "grpc_method"})
+}
+
+// UnaryServerInterceptor bounds rpcs without a deadline by defaultTimeout,
+// unless it is 0, and records the remaining deadline budget of every rpc in
+// the grpc_server_deadline_remaining_seconds histogram registered with reg
+// and the grpc.deadline_remaining_ms log field.
+func UnaryServerInterceptor(defaultTimeout time.Duration, reg prometheus.Registerer) grpc.UnaryServerInterceptor {
+	remainingSeconds := newRemainingSeconds(reg)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if _, ok := ctx.Deadline(); !ok && defaultTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+			defer cancel()
+			grpc_ctxtags.Extract(ctx).Set("grpc.deadline_default", true)
+		}
+		record(ctx, remainingSeconds, info.FullMethod)
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor records the remaining deadline budget of streams.
+// Streams without a deadline are left unbounded, as they may be long lived.
+func StreamServerInterceptor(reg prometheus.Registerer) grpc.StreamServerInterceptor {
+	remainingSeconds := newRemainingSeconds(reg)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		record(ss.Context(), remainingSeconds, info.FullMethod)
+		return handler(srv, ss)
+	}
+}
+
+func record(ctx context.Context, remainingSeconds *prometheus.HistogramVec, fullMethod string) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return
+	}
+	remaining := time.Until(deadline)
+
+	service, method := path.Split(fullMethod)
+	remainingSeconds.WithLabelValues(path.Base(service), method).Observe(remaining.Seconds())
+	grpc_ctxtags.Extract(ctx).Set("grpc.deadline_remaining_ms", remaining.Nanoseconds()/int64(time.Millisecond))
+}
@@ -0,0 +1,198 @@
+package deadline
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// testServerStream is a server stream with the given context.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context { return s.ctx }
+
+// remaining returns the number of observations and their sum in the
+// remaining deadline histogram of /com.Pinger/Ping.
+func remaining(t *testing.T, reg *prometheus.Registry) (uint64, float64) {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "grpc_server_deadline_remaining_seconds" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["grpc_service"] == "com.Pinger" && labels["grpc_method"] == "Ping" {
+				return m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum()
+			}
+		}
+	}
+	return 0, 0
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name           string
+		defaultTimeout time.Duration
+		// timeout of the incoming rpc, none if 0
+		timeout time.Duration
+		// deadline the handler sees, relative to the start of the rpc
+		deadline      time.Duration
+		defaultTagged bool
+	}{
+		{
+			name:           "default timeout",
+			defaultTimeout: time.Second,
+			deadline:       time.Second,
+			defaultTagged:  true,
+		},
+		{
+			name: "no default timeout",
+		},
+		{
+			name:           "longer deadline kept",
+			defaultTimeout: time.Second,
+			timeout:        5 * time.Second,
+			deadline:       5 * time.Second,
+		},
+		{
+			name:           "shorter deadline kept",
+			defaultTimeout: time.Second,
+			timeout:        100 * time.Millisecond,
+			deadline:       100 * time.Millisecond,
+		},
+		{
+			name:     "deadline without default timeout",
+			timeout:  time.Second,
+			deadline: time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			interceptor := grpc_middleware.ChainUnaryServer(
+				grpc_ctxtags.UnaryServerInterceptor(),
+				UnaryServerInterceptor(tt.defaultTimeout, reg),
+			)
+
+			ctx := context.Background()
+			start := time.Now()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+
+			var tags map[string]interface{}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				deadline, ok := ctx.Deadline()
+				if ok != (tt.deadline > 0) {
+					t.Fatalf("has deadline = %v, want %v", ok, tt.deadline > 0)
+				}
+				if want := start.Add(tt.deadline); ok && (deadline.Before(want) || deadline.Sub(want) > 50*time.Millisecond) {
+					t.Errorf("deadline = %v after the start, want %v", deadline.Sub(start), tt.deadline)
+				}
+				tags = grpc_ctxtags.Extract(ctx).Values()
+				return nil, nil
+			}
+			if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{FullMethod: "/com.Pinger/Ping"}, handler); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if tagged := tags["grpc.deadline_default"] == true; tagged != tt.defaultTagged {
+				t.Errorf("grpc.deadline_default tagged = %v, want %v", tagged, tt.defaultTagged)
+			}
+			ms, ok := tags["grpc.deadline_remaining_ms"].(int64)
+			if ok != (tt.deadline > 0) {
+				t.Fatalf("grpc.deadline_remaining_ms tagged = %v, want %v", ok, tt.deadline > 0)
+			}
+			if ok && (ms <= 0 || ms > int64(tt.deadline/time.Millisecond)) {
+				t.Errorf("grpc.deadline_remaining_ms = %v, want up to %v", ms, int64(tt.deadline/time.Millisecond))
+			}
+
+			count, sum := remaining(t, reg)
+			if tt.deadline == 0 {
+				if count != 0 {
+					t.Errorf("remaining observations = %v, want 0", count)
+				}
+				return
+			}
+			if count != 1 {
+				t.Fatalf("remaining observations = %v, want 1", count)
+			}
+			if sum <= 0 || sum > tt.deadline.Seconds() {
+				t.Errorf("remaining = %vs, want up to %v", sum, tt.deadline)
+			}
+		})
+	}
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout time.Duration
+	}{
+		{name: "deadline", timeout: time.Second},
+		{name: "no deadline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			interceptor := grpc_middleware.ChainStreamServer(
+				grpc_ctxtags.StreamServerInterceptor(),
+				StreamServerInterceptor(reg),
+			)
+
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			want, _ := ctx.Deadline()
+
+			var tags map[string]interface{}
+			handler := func(srv interface{}, ss grpc.ServerStream) error {
+				// streams without a deadline stay unbounded
+				deadline, _ := ss.Context().Deadline()
+				if !deadline.Equal(want) {
+					t.Errorf("deadline = %v, want %v", deadline, want)
+				}
+				tags = grpc_ctxtags.Extract(ss.Context()).Values()
+				return nil
+			}
+			info := &grpc.StreamServerInfo{FullMethod: "/com.Pinger/Ping"}
+			if err := interceptor(nil, &testServerStream{ctx: ctx}, info, handler); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			_, tagged := tags["grpc.deadline_remaining_ms"]
+			if tagged != (tt.timeout > 0) {
+				t.Errorf("grpc.deadline_remaining_ms tagged = %v, want %v", tagged, tt.timeout > 0)
+			}
+			if _, ok := tags["grpc.deadline_default"]; ok {
+				t.Errorf("grpc.deadline_default tagged on a stream")
+			}
+			wantCount := uint64(0)
+			if tt.timeout > 0 {
+				wantCount = 1
+			}
+			if count, _ := remaining(t, reg); count != wantCount {
+				t.Errorf("remaining observations = %v, want %v", count, wantCount)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package fault
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// ParseRequest parses faults to request from a server, e.g.
+// "latency=300ms,error=Unavailable,reset", into metadata headers.
+func ParseRequest(spec string) (metadata.MD, error) {
+	md := metadata.MD{}
+	if spec == "" {
+		return md, nil
+	}
+	for _, fault := range strings.Split(spec, ",") {
+		parts := strings.SplitN(strings.TrimSpace(fault), "=", 2)
+		switch {
+		case parts[0] == "latency" && len(parts) == 2:
+			if _, err := time.ParseDuration(parts[1]); err != nil {
+				return nil, fmt.Errorf("invalid fault latency: %v", err.Error())
+			}
+			md[LatencyHeader] = []string{parts[1]}
+		case parts[0] == "error" && len(parts) == 2:
+			if _, err := parseCode(parts[1]); err != nil {
+				return nil, err
+			}
+			md[ErrorHeader] = []string{parts[1]}
+		case parts[0] == "reset" && len(parts) == 1:
+			md[ResetHeader] = []string{"true"}
+		default:
+			return nil, fmt.Errorf("invalid fault %q", fault)
+		}
+	}
+	return md, nil
+}
+
+// UnaryClientInterceptor sends the fault headers of the rpc being handled,
+// so that faults requested from a service reach its dependencies, or else md
+// with every unary rpc.
+func UnaryClientInterceptor(md metadata.MD) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		faults := md
+		if incoming, ok := metadata.FromIncomingContext(ctx); ok {
+			forwarded := metadata.MD{}
+			for _, key := range []string{LatencyHeader, ErrorHeader, ResetHeader} {
+				if v := incoming[key]; len(v) > 0 {
+					forwarded[key] = v
+				}
+			}
+			if forwarded.Len() > 0 {
+				faults = forwarded
+			}
+		}
+		if faults.Len() > 0 {
+			outgoing, _ := metadata.FromOutgoingContext(ctx)
+			ctx = metadata.NewOutgoingContext(ctx, metadata.Join(outgoing, faults))
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
@@ -0,0 +1,81 @@
+package fault
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParseRequest(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    metadata.MD
+		wantErr bool
+	}{
+		{spec: "", want: metadata.MD{}},
+		{spec: "latency=300ms", want: metadata.MD{LatencyHeader: {"300ms"}}},
+		{
+			spec: "latency=300ms, error=Unavailable,reset",
+			want: metadata.MD{LatencyHeader: {"300ms"}, ErrorHeader: {"Unavailable"}, ResetHeader: {"true"}},
+		},
+		{spec: "latency=300", wantErr: true},
+		{spec: "error=Broken", wantErr: true},
+		{spec: "reset=true", wantErr: true},
+		{spec: "latency", wantErr: true},
+		{spec: "crash", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseRequest(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRequest(%q) error = %v, wantErr %v", tt.spec, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseRequest(%q) = %v, want %v", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	requested := metadata.MD{LatencyHeader: {"10ms"}}
+	tests := []struct {
+		name string
+		md   metadata.MD
+		ctx  context.Context
+		want metadata.MD
+	}{
+		{name: "none", md: metadata.MD{}, ctx: context.Background(), want: nil},
+		{name: "requested", md: requested, ctx: context.Background(), want: requested},
+		{
+			name: "forwarded over requested",
+			md:   requested,
+			ctx:  metadata.NewIncomingContext(context.Background(), metadata.MD{ErrorHeader: {"Internal"}, "other": {"x"}}),
+			want: metadata.MD{ErrorHeader: {"Internal"}},
+		},
+		{
+			name: "requested without incoming faults",
+			md:   requested,
+			ctx:  metadata.NewIncomingContext(context.Background(), metadata.MD{"other": {"x"}}),
+			want: requested,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got metadata.MD
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				got, _ = metadata.FromOutgoingContext(ctx)
+				return nil
+			}
+			if err := UnaryClientInterceptor(tt.md)(tt.ctx, "/test/Method", nil, nil, nil, invoker); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outgoing metadata = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"sort"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// Latency distributions.
+const (
+	// None adds no latency.
+	None = ""
+	// Fixed adds Mean.
+	Fixed = "fixed"
+	// Uniform adds a latency between Min and Max.
+	Uniform = "uniform"
+	// Normal adds a normally distributed latency around Mean.
+	Normal = "normal"
+	// LongTail adds a log-normally distributed latency with median Mean, whose
+	// tail grows with Sigma.
+	LongTail = "long-tail"
+)
+
+// Duration is a time.Duration written as a duration string in json, e.g.
+// "150ms".
+type Duration time.Duration
+
+// MarshalJSON writes d as a duration string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// UnmarshalJSON reads d from a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	duration, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// Latency is the latency added to every rpc. Except for uniform latencies,
+// Min and Max clamp the latency when set.
+type Latency struct {
+	Distribution string   `json:"distribution"`
+	Min          Duration `json:"min,omitempty"`
+	Max          Duration `json:"max,omitempty"`
+	Mean         Duration `json:"mean,omitempty"`
+	StdDev       Duration `json:"stddev,omitempty"`
+	Sigma        float64  `json:"sigma,omitempty"`
+}
+
+func (l Latency) sample() time.Duration {
+	var d float64
+	switch l.Distribution {
+	case None:
+		return 0
+	case Fixed:
+		d = float64(l.Mean)
+	case Uniform:
+		d = float64(l.Min) + rand.Float64()*float64(l.Max-l.Min)
+	case Normal:
+		d = float64(l.Mean) + rand.NormFloat64()*float64(l.StdDev)
+	case LongTail:
+		d = float64(l.Mean) * math.Exp(rand.NormFloat64()*l.Sigma)
+	}
+
+	d = math.Max(d, float64(l.Min))
+	if l.Max > 0 {
+		d = math.Min(d, float64(l.Max))
+	}
+	return time.Duration(d)
+}
+
+// Config is the set of faults injected into rpcs.
+type Config struct {
+	Latency Latency `json:"latency"`
+	// Errors maps grpc code names, e.g. Unavailable, to the share of rpcs
+	// failing with that code.
+	Errors map[string]float64 `json:"errors,omitempty"`
+	// ResetRate is the share of rpcs whose connection is reset.
+	ResetRate float64 `json:"reset_rate,omitempty"`
+}
+
+// LoadConfig reads a json config from path.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("failed to read %v: %v", path, err.Error())
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %v: %v", path, err.Error())
+	}
+	return config, nil
+}
+
+// Validate checks that the distribution is known and the rates are shares.
+func (c Config) Validate() error {
+	l := c.Latency
+	switch l.Distribution {
+	case None, Fixed, Uniform, Normal, LongTail:
+	default:
+		return fmt.Errorf("unknown latency distribution %q", l.Distribution)
+	}
+	if l.Min < 0 || l.Max < 0 || l.Mean < 0 || l.StdDev < 0 || l.Sigma < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+	if l.Max > 0 && l.Min > l.Max {
+		return fmt.Errorf("latency min must not exceed max")
+	}
+	if l.Distribution == Uniform && l.Max == 0 {
+		return fmt.Errorf("uniform latency requires max")
+	}
+
+	var total float64
+	for name, rate := range c.Errors {
+		if _, err := parseCode(name); err != nil {
+			return err
+		}
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("error rate of %v must be between 0 and 1", name)
+		}
+		total += rate
+	}
+	if total > 1 {
+		return fmt.Errorf("error rates must not add up to more than 1")
+	}
+	if c.ResetRate < 0 || c.ResetRate > 1 {
+		return fmt.Errorf("reset rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// sampleError picks the code an rpc fails with by the error rates, or OK.
+func (c Config) sampleError() codes.Code {
+	names := make([]string, 0, len(c.Errors))
+	for name := range c.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	r := rand.Float64()
+	for _, name := range names {
+		r -= c.Errors[name]
+		if r < 0 {
+			code, _ := parseCode(name) // validated with the config
+			return code
+		}
+	}
+	return codes.OK
+}
+
+func parseCode(name string) (codes.Code, error) {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if c.String() == name {
+			return c, nil
+		}
+	}
+	return codes.OK, fmt.Errorf("unknown grpc code %q", name)
+}
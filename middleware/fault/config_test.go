@@ -0,0 +1,166 @@
+package fault
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{name: "empty", config: Config{}},
+		{name: "fixed", config: Config{Latency: Latency{Distribution: Fixed, Mean: Duration(time.Millisecond)}}},
+		{name: "uniform", config: Config{Latency: Latency{Distribution: Uniform, Min: Duration(time.Millisecond), Max: Duration(time.Second)}}},
+		{name: "uniform without max", config: Config{Latency: Latency{Distribution: Uniform}}, wantErr: true},
+		{name: "unknown distribution", config: Config{Latency: Latency{Distribution: "poisson"}}, wantErr: true},
+		{name: "negative latency", config: Config{Latency: Latency{Distribution: Fixed, Mean: Duration(-time.Millisecond)}}, wantErr: true},
+		{name: "min over max", config: Config{Latency: Latency{Distribution: Normal, Min: Duration(time.Second), Max: Duration(time.Millisecond)}}, wantErr: true},
+		{name: "errors", config: Config{Errors: map[string]float64{"Unavailable": 0.5, "Internal": 0.5}}},
+		{name: "unknown code", config: Config{Errors: map[string]float64{"Broken": 0.1}}, wantErr: true},
+		{name: "error rate over 1", config: Config{Errors: map[string]float64{"Unavailable": 1.5}}, wantErr: true},
+		{name: "error rates add up over 1", config: Config{Errors: map[string]float64{"Unavailable": 0.6, "Internal": 0.6}}, wantErr: true},
+		{name: "reset rate", config: Config{ResetRate: 0.1}},
+		{name: "negative reset rate", config: Config{ResetRate: -0.1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.config.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fault")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		data    string
+		want    Config
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			data: `{"latency": {"distribution": "long-tail", "mean": "20ms", "sigma": 0.5, "max": "2s"}, "errors": {"Unavailable": 0.01}, "reset_rate": 0.001}`,
+			want: Config{
+				Latency:   Latency{Distribution: LongTail, Mean: Duration(20 * time.Millisecond), Sigma: 0.5, Max: Duration(2 * time.Second)},
+				Errors:    map[string]float64{"Unavailable": 0.01},
+				ResetRate: 0.001,
+			},
+		},
+		{name: "invalid duration", data: `{"latency": {"distribution": "fixed", "mean": "20"}}`, wantErr: true},
+		{name: "invalid json", data: `{`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, "faults.json")
+			if err := ioutil.WriteFile(path, []byte(tt.data), 0600); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadConfig(path)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadConfig() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadConfig(filepath.Join(dir, "missing.json")); err == nil {
+		t.Error("LoadConfig() of a missing file succeeded")
+	}
+}
+
+func TestDurationJSON(t *testing.T) {
+	data, err := json.Marshal(Duration(150 * time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `"150ms"` {
+		t.Errorf("Marshal() = %s, want \"150ms\"", data)
+	}
+	var d Duration
+	if err := json.Unmarshal(data, &d); err != nil {
+		t.Fatal(err)
+	}
+	if d != Duration(150*time.Millisecond) {
+		t.Errorf("Unmarshal() = %v, want 150ms", time.Duration(d))
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	ms := func(n int) Duration { return Duration(time.Duration(n) * time.Millisecond) }
+	tests := []struct {
+		name     string
+		latency  Latency
+		min, max time.Duration
+	}{
+		{name: "none", latency: Latency{}, min: 0, max: 0},
+		{name: "fixed", latency: Latency{Distribution: Fixed, Mean: ms(20)}, min: 20 * time.Millisecond, max: 20 * time.Millisecond},
+		{name: "uniform", latency: Latency{Distribution: Uniform, Min: ms(10), Max: ms(30)}, min: 10 * time.Millisecond, max: 30 * time.Millisecond},
+		{name: "normal clamped", latency: Latency{Distribution: Normal, Mean: ms(20), StdDev: ms(50), Min: ms(5), Max: ms(40)}, min: 5 * time.Millisecond, max: 40 * time.Millisecond},
+		{name: "normal not negative", latency: Latency{Distribution: Normal, Mean: ms(1), StdDev: ms(50)}, min: 0, max: time.Hour},
+		{name: "long tail clamped", latency: Latency{Distribution: LongTail, Mean: ms(20), Sigma: 2, Max: ms(100)}, min: 0, max: 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 1000; i++ {
+				if d := tt.latency.sample(); d < tt.min || d > tt.max {
+					t.Fatalf("sample() = %v, want between %v and %v", d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestSampleError(t *testing.T) {
+	tests := []struct {
+		name   string
+		errors map[string]float64
+		want   map[codes.Code]float64
+	}{
+		{name: "none", want: map[codes.Code]float64{codes.OK: 1}},
+		{name: "always", errors: map[string]float64{"Unavailable": 1}, want: map[codes.Code]float64{codes.Unavailable: 1}},
+		{
+			name:   "shares",
+			errors: map[string]float64{"Unavailable": 0.2, "Internal": 0.3},
+			want:   map[codes.Code]float64{codes.OK: 0.5, codes.Unavailable: 0.2, codes.Internal: 0.3},
+		},
+	}
+	const n = 10000
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := Config{Errors: tt.errors}
+			counts := map[codes.Code]int{}
+			for i := 0; i < n; i++ {
+				counts[config.sampleError()]++
+			}
+			for code := range counts {
+				if _, ok := tt.want[code]; !ok {
+					t.Errorf("sampled unexpected code %v", code)
+				}
+			}
+			for code, share := range tt.want {
+				if got := float64(counts[code]) / n; got < share-0.03 || got > share+0.03 {
+					t.Errorf("share of %v = %v, want %v", code, got, share)
+				}
+			}
+		})
+	}
+}
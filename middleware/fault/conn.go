@@ -0,0 +1,67 @@
+package fault
+
+import (
+	"net"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/peer"
+)
+
+// WrapListener returns a listener tracking the connections it accepts, so
+// that the injector can reset them.
+func (i *Injector) WrapListener(lis net.Listener) net.Listener {
+	return &listener{Listener: lis, injector: i}
+}
+
+type listener struct {
+	net.Listener
+	injector *Injector
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return conn, nil
+	}
+
+	addr := conn.RemoteAddr().String()
+	l.injector.connsMu.Lock()
+	l.injector.conns[addr] = tcpConn
+	l.injector.connsMu.Unlock()
+	return &trackedConn{TCPConn: tcpConn, injector: l.injector, addr: addr}, nil
+}
+
+type trackedConn struct {
+	*net.TCPConn
+	injector *Injector
+	addr     string
+}
+
+func (c *trackedConn) Close() error {
+	c.injector.connsMu.Lock()
+	delete(c.injector.conns, c.addr)
+	c.injector.connsMu.Unlock()
+	return c.TCPConn.Close()
+}
+
+// reset closes the connection of the rpc of ctx with a tcp reset. Nothing is
+// reset if the connection was not accepted by a wrapped listener.
+func (i *Injector) reset(ctx context.Context) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return
+	}
+	i.connsMu.Lock()
+	conn, ok := i.conns[p.Addr.String()]
+	delete(i.conns, p.Addr.String())
+	i.connsMu.Unlock()
+	if !ok {
+		return
+	}
+	conn.SetLinger(0)
+	conn.Close()
+}
@@ -0,0 +1,200 @@
+// Package fault injects latency, errors and connection resets into rpcs, to
+// exercise clients and the observability stack. Faults are configured for
+// all rpcs at runtime over http, or triggered per rpc by metadata headers.
+package fault
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/mad01/pingpong/grpcutil"
+	"github.com/mad01/pingpong/middleware/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// exemptPrefix is the prefix of the methods faults are never injected into,
+// so that health checks keep reporting the real health.
+const exemptPrefix = "/grpc.health.v1.Health/"
+
+// Metadata headers triggering faults for a single rpc.
+const (
+	// LatencyHeader adds the latency given as duration string.
+	LatencyHeader = "x-fault-latency"
+	// ErrorHeader fails the rpc with the code given by name.
+	ErrorHeader = "x-fault-error"
+	// ResetHeader resets the connection when set to true.
+	ResetHeader = "x-fault-reset"
+)
+
+// Injector injects the faults of its config into rpcs.
+type Injector struct {
+	headers       bool
+	injectedTotal *prometheus.CounterVec
+
+	mu     sync.RWMutex
+	config Config
+
+	connsMu sync.Mutex
+	conns   map[string]*net.TCPConn
+}
+
+// NewInjector creates an injector with config. Faults requested by metadata
+// headers are only injected if headers is set. Injected faults are counted
+// in grpc_server_faults_injected_total registered with reg.
+func NewInjector(config Config, headers bool, reg prometheus.Registerer) (*Injector, error) {
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &Injector{
+		headers: headers,
+		injectedTotal: metrics.NewCounterVec(reg, prometheus.CounterOpts{
+			Namespace: "grpc",
+			Subsystem: "server",
+			Name:      "faults_injected_total",
+			Help:      "Total number of errors and connection resets injected into rpcs.",
+		}, []string{"grpc_service", "grpc_method", "fault"}),
+		config: config,
+		conns:  map[string]*net.TCPConn{},
+	}, nil
+}
+
+// Config returns the current config.
+func (i *Injector) Config() Config {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.config
+}
+
+// SetConfig replaces the config for the following rpcs.
+func (i *Injector) SetConfig(config Config) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.config = config
+	i.mu.Unlock()
+	return nil
+}
+
+// ServeHTTP returns the config as json on GET and replaces it with the json
+// body of a PUT.
+func (i *Injector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var config Config
+		if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+			http.Error(w, fmt.Sprintf("invalid config: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+		if err := i.SetConfig(config); err != nil {
+			http.Error(w, fmt.Sprintf("invalid config: %v", err.Error()), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(i.Config())
+}
+
+// Inject delays the rpc and returns the error it fails with, if any.
+func (i *Injector) Inject(ctx context.Context, fullMethod string) error {
+	config := i.Config()
+	latency := config.Latency.sample()
+	code := config.sampleError()
+	reset := config.ResetRate > 0 && rand.Float64() < config.ResetRate
+	if i.headers {
+		var err error
+		latency, code, reset, err = fromHeaders(ctx, latency, code, reset)
+		if err != nil {
+			return err
+		}
+	}
+
+	tags := grpc_ctxtags.Extract(ctx)
+	if latency > 0 {
+		tags.Set("fault.latency_ms", int64(latency/time.Millisecond))
+		select {
+		case <-ctx.Done():
+			return grpcutil.ContextError(ctx)
+		case <-time.After(latency):
+		}
+	}
+
+	service, method := path.Split(fullMethod)
+	if reset {
+		tags.Set("fault.reset", true)
+		i.injectedTotal.WithLabelValues(path.Base(service), method, "reset").Inc()
+		i.reset(ctx)
+		return status.Error(codes.Unavailable, "connection reset by fault injection")
+	}
+	if code != codes.OK {
+		tags.Set("fault.error", code.String())
+		i.injectedTotal.WithLabelValues(path.Base(service), method, "error").Inc()
+		return status.Errorf(code, "%v injected by fault injection", code)
+	}
+	return nil
+}
+
+// fromHeaders overrides the sampled faults with those requested by the
+// metadata of ctx.
+func fromHeaders(ctx context.Context, latency time.Duration, code codes.Code, reset bool) (time.Duration, codes.Code, bool, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return latency, code, reset, nil
+	}
+	if v := md[LatencyHeader]; len(v) > 0 {
+		d, err := time.ParseDuration(v[0])
+		if err != nil || d < 0 {
+			return 0, codes.OK, false, status.Errorf(codes.InvalidArgument, "invalid %v: %v", LatencyHeader, v[0])
+		}
+		latency = d
+	}
+	if v := md[ErrorHeader]; len(v) > 0 {
+		c, err := parseCode(v[0])
+		if err != nil {
+			return 0, codes.OK, false, status.Errorf(codes.InvalidArgument, "invalid %v: %v", ErrorHeader, err.Error())
+		}
+		code = c
+	}
+	if v := md[ResetHeader]; len(v) > 0 {
+		r, err := strconv.ParseBool(v[0])
+		if err != nil {
+			return 0, codes.OK, false, status.Errorf(codes.InvalidArgument, "invalid %v: %v", ResetHeader, v[0])
+		}
+		reset = r
+	}
+	return latency, code, reset, nil
+}
+
+// UnaryServerInterceptor injects faults into unary rpcs before they reach the
+// handler.
+func UnaryServerInterceptor(i *Injector) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, exemptPrefix) {
+			return handler(ctx, req)
+		}
+		if err := i.Inject(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
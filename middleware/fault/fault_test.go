@@ -0,0 +1,92 @@
+package fault
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInject(t *testing.T) {
+	tests := []struct {
+		name        string
+		config      Config
+		headers     bool
+		md          metadata.MD
+		want        codes.Code
+		wantLatency time.Duration
+	}{
+		{name: "no faults", want: codes.OK},
+		{name: "error", config: Config{Errors: map[string]float64{"Internal": 1}}, want: codes.Internal},
+		{name: "reset", config: Config{ResetRate: 1}, want: codes.Unavailable},
+		{
+			name:        "latency",
+			config:      Config{Latency: Latency{Distribution: Fixed, Mean: Duration(20 * time.Millisecond)}},
+			want:        codes.OK,
+			wantLatency: 20 * time.Millisecond,
+		},
+		{name: "error header", headers: true, md: metadata.Pairs(ErrorHeader, "Unavailable"), want: codes.Unavailable},
+		{name: "error header disabled", md: metadata.Pairs(ErrorHeader, "Unavailable"), want: codes.OK},
+		{
+			name:        "latency header",
+			headers:     true,
+			md:          metadata.Pairs(LatencyHeader, "20ms"),
+			want:        codes.OK,
+			wantLatency: 20 * time.Millisecond,
+		},
+		{
+			name:    "header overrides config",
+			config:  Config{Errors: map[string]float64{"Internal": 1}},
+			headers: true,
+			md:      metadata.Pairs(ErrorHeader, "OK"),
+			want:    codes.OK,
+		},
+		{name: "invalid latency header", headers: true, md: metadata.Pairs(LatencyHeader, "-1s"), want: codes.InvalidArgument},
+		{name: "invalid error header", headers: true, md: metadata.Pairs(ErrorHeader, "Broken"), want: codes.InvalidArgument},
+		{name: "invalid reset header", headers: true, md: metadata.Pairs(ResetHeader, "maybe"), want: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			injector, err := NewInjector(tt.config, tt.headers, prometheus.NewRegistry())
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if tt.md != nil {
+				ctx = metadata.NewIncomingContext(ctx, tt.md)
+			}
+			start := time.Now()
+			err = injector.Inject(ctx, "/test.Service/Method")
+			if code := grpc.Code(err); code != tt.want {
+				t.Errorf("Inject() code = %v, want %v", code, tt.want)
+			}
+			if elapsed := time.Since(start); elapsed < tt.wantLatency {
+				t.Errorf("Inject() took %v, want at least %v", elapsed, tt.wantLatency)
+			}
+		})
+	}
+}
+
+func TestNewInjectorInvalidConfig(t *testing.T) {
+	config := Config{Errors: map[string]float64{"Broken": 1}}
+	if _, err := NewInjector(config, false, prometheus.NewRegistry()); err == nil {
+		t.Error("NewInjector() with an invalid config succeeded")
+	}
+}
+
+func TestInjectCanceled(t *testing.T) {
+	config := Config{Latency: Latency{Distribution: Fixed, Mean: Duration(time.Minute)}}
+	injector, err := NewInjector(config, false, prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if code := grpc.Code(injector.Inject(ctx, "/test.Service/Method")); code != codes.DeadlineExceeded {
+		t.Errorf("Inject() code = %v, want %v", code, codes.DeadlineExceeded)
+	}
+}
@@ -0,0 +1,157 @@
+package limit
+
+import (
+	"math"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// shortAlpha and longAlpha weigh new latency samples in the short and
+	// long term latency averages.
+	shortAlpha = 0.2
+	longAlpha  = 0.01
+	// smoothing weighs a new limit against the current one.
+	smoothing = 0.2
+)
+
+// shares are the shares of the limit the rpcs of each priority may use, so
+// that lower priority rpcs are shed before the server is at its limit.
+var shares = map[Priority]float64{
+	Low:    0.5,
+	Normal: 0.9,
+	High:   1,
+}
+
+type adaptiveOptions struct {
+	initialLimit float64
+	minLimit     float64
+	maxLimit     float64
+	tolerance    float64
+}
+
+func defaultAdaptiveOptions() adaptiveOptions {
+	return adaptiveOptions{
+		initialLimit: 20,
+		minLimit:     4,
+		maxLimit:     1000,
+		tolerance:    2,
+	}
+}
+
+// AdaptiveOption configures an AdaptiveLimiter.
+type AdaptiveOption func(*adaptiveOptions)
+
+// WithLimits sets the initial concurrency limit and the bounds it adapts
+// within.
+func WithLimits(initial, min, max int) AdaptiveOption {
+	return func(o *adaptiveOptions) {
+		o.initialLimit = float64(initial)
+		o.minLimit = float64(min)
+		o.maxLimit = float64(max)
+	}
+}
+
+// WithTolerance sets how many times slower than the long term average the
+// short term latency may get before the limit is lowered.
+func WithTolerance(tolerance float64) AdaptiveOption {
+	return func(o *adaptiveOptions) {
+		o.tolerance = tolerance
+	}
+}
+
+// AdaptiveLimiter limits the rpcs in flight on a server to a limit that
+// follows the observed latency. While the short term latency stays within
+// tolerance of the long term latency the limit grows with the load, once
+// rpcs queue up and the latency degrades the limit shrinks by the gradient
+// between them. Rpcs of lower priority may only use a share of the limit and
+// are shed first.
+type AdaptiveLimiter struct {
+	opts    adaptiveOptions
+	metrics *limitMetrics
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	short    float64
+	long     float64
+}
+
+// NewAdaptiveLimiter creates an adaptive limiter. The current limit is
+// exported as grpc_server_adaptive_limit registered with reg.
+func NewAdaptiveLimiter(reg prometheus.Registerer, opts ...AdaptiveOption) *AdaptiveLimiter {
+	o := defaultAdaptiveOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return &AdaptiveLimiter{
+		opts:    o,
+		metrics: newLimitMetrics(reg),
+		limit:   o.initialLimit,
+	}
+}
+
+// Admit admits the rpc if the rpcs in flight are below the share of the
+// limit its priority may use.
+func (l *AdaptiveLimiter) Admit(ctx context.Context, fullMethod string) (func(), error) {
+	priority := PriorityFromContext(ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if float64(l.inFlight) >= math.Max(1, l.limit*shares[priority]) {
+		l.metrics.reject(fullMethod, "load_shed")
+		return nil, status.Errorf(codes.ResourceExhausted, "server overloaded, %v priority rpc shed", priority)
+	}
+	l.inFlight++
+
+	start := time.Now()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.sample(fullMethod, time.Since(start))
+		})
+	}, nil
+}
+
+// sample updates the limit with the latency of a finished rpc.
+func (l *AdaptiveLimiter) sample(fullMethod string, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	inFlight := l.inFlight
+	l.inFlight--
+
+	seconds := latency.Seconds()
+	if l.long == 0 {
+		l.short, l.long = seconds, seconds
+	}
+	l.short += shortAlpha * (seconds - l.short)
+	l.long += longAlpha * (seconds - l.long)
+	if l.long > l.short*l.opts.tolerance {
+		// recover faster from a latency spike that raised the long term average
+		l.long *= 0.95
+	}
+
+	gradient := 1.0
+	if l.short > 0 {
+		gradient = math.Max(0.5, math.Min(1, l.opts.tolerance*l.long/l.short))
+	}
+	if gradient == 1 && float64(inFlight) < l.limit/2 {
+		// the limit is not what holds the rpcs back
+		return
+	}
+
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	l.limit = l.limit*(1-smoothing) + newLimit*smoothing
+	l.limit = math.Max(l.opts.minLimit, math.Min(l.opts.maxLimit, l.limit))
+
+	service, _ := path.Split(fullMethod)
+	l.metrics.limit.WithLabelValues(path.Base(service)).Set(l.limit)
+}
@@ -0,0 +1,130 @@
+package limit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func withPriority(p Priority) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs(priorityKey, p.String()))
+}
+
+func TestAdaptiveLimiterShares(t *testing.T) {
+	tests := []struct {
+		priority Priority
+		admitted int
+	}{
+		{priority: Low, admitted: 5},
+		{priority: Normal, admitted: 9},
+		{priority: High, admitted: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.priority.String(), func(t *testing.T) {
+			l := NewAdaptiveLimiter(prometheus.NewRegistry(), WithLimits(10, 4, 100))
+			ctx := withPriority(tt.priority)
+			for i := 0; i < tt.admitted; i++ {
+				if _, err := l.Admit(ctx, "/test/Method"); err != nil {
+					t.Fatalf("rpc %v: %v", i, err)
+				}
+			}
+			if _, err := l.Admit(ctx, "/test/Method"); grpc.Code(err) != codes.ResourceExhausted {
+				t.Errorf("rpc %v: got %v, want it shed", tt.admitted, err)
+			}
+		})
+	}
+}
+
+func TestAdaptiveLimiterDone(t *testing.T) {
+	l := NewAdaptiveLimiter(prometheus.NewRegistry(), WithLimits(1, 1, 1))
+	done, err := l.Admit(withPriority(High), "/test/Method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := l.Admit(withPriority(High), "/test/Method"); err == nil {
+		t.Fatal("rpc over the limit was admitted")
+	}
+	done()
+	done()
+	if l.inFlight != 0 {
+		t.Fatalf("inFlight = %v after done, want 0", l.inFlight)
+	}
+	if _, err := l.Admit(withPriority(High), "/test/Method"); err != nil {
+		t.Errorf("rpc after done: %v", err)
+	}
+}
+
+func TestAdaptiveLimiterGradient(t *testing.T) {
+	tests := []struct {
+		name      string
+		opts      []AdaptiveOption
+		inFlight  int
+		latencies []time.Duration
+		check     func(limit float64) bool
+		want      string
+	}{
+		{
+			name:      "grows under load at steady latency",
+			inFlight:  15,
+			latencies: repeatLatency(10*time.Millisecond, 5),
+			check:     func(limit float64) bool { return limit > 20 },
+			want:      "> 20",
+		},
+		{
+			name:      "unchanged under light load",
+			inFlight:  1,
+			latencies: repeatLatency(10*time.Millisecond, 5),
+			check:     func(limit float64) bool { return limit == 20 },
+			want:      "20",
+		},
+		{
+			name:      "shrinks when latency degrades",
+			inFlight:  15,
+			latencies: append(repeatLatency(10*time.Millisecond, 1), repeatLatency(time.Second, 3)...),
+			check:     func(limit float64) bool { return limit < 20 },
+			want:      "< 20",
+		},
+		{
+			name:      "bounded by max",
+			opts:      []AdaptiveOption{WithLimits(20, 4, 25)},
+			inFlight:  15,
+			latencies: repeatLatency(10*time.Millisecond, 100),
+			check:     func(limit float64) bool { return limit == 25 },
+			want:      "25",
+		},
+		{
+			name:      "bounded by min",
+			opts:      []AdaptiveOption{WithLimits(20, 18, 100)},
+			inFlight:  15,
+			latencies: append(repeatLatency(10*time.Millisecond, 1), repeatLatency(time.Second, 10)...),
+			check:     func(limit float64) bool { return limit == 18 },
+			want:      "18",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewAdaptiveLimiter(prometheus.NewRegistry(), tt.opts...)
+			for _, latency := range tt.latencies {
+				// sample counts the finished rpc out of the rpcs in flight
+				l.inFlight = tt.inFlight + 1
+				l.sample("/test/Method", latency)
+			}
+			if !tt.check(l.limit) {
+				t.Errorf("limit = %v, want %v", l.limit, tt.want)
+			}
+		})
+	}
+}
+
+func repeatLatency(latency time.Duration, n int) []time.Duration {
+	latencies := make([]time.Duration, n)
+	for i := range latencies {
+		latencies[i] = latency
+	}
+	return latencies
+}
@@ -0,0 +1,57 @@
+package limit
+
+import (
+	"path"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ConcurrencyLimiter limits the number of rpcs in flight per method.
+type ConcurrencyLimiter struct {
+	max     int
+	metrics *limitMetrics
+
+	mu       sync.Mutex
+	inFlight map[string]int
+}
+
+// NewConcurrencyLimiter creates a limiter admitting up to max rpcs in flight
+// for every method. The rpcs in flight are exported as grpc_server_in_flight
+// registered with reg.
+func NewConcurrencyLimiter(max int, reg prometheus.Registerer) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		max:      max,
+		metrics:  newLimitMetrics(reg),
+		inFlight: map[string]int{},
+	}
+}
+
+// Admit admits the rpc if fewer than max rpcs of the method are in flight.
+func (l *ConcurrencyLimiter) Admit(ctx context.Context, fullMethod string) (func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.inFlight[fullMethod] >= l.max {
+		l.metrics.reject(fullMethod, "concurrency_limit")
+		return nil, status.Errorf(codes.ResourceExhausted, "too many rpcs in flight for %v", fullMethod)
+	}
+	l.inFlight[fullMethod]++
+
+	service, method := path.Split(fullMethod)
+	gauge := l.metrics.inFlight.WithLabelValues(path.Base(service), method)
+	gauge.Inc()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			l.inFlight[fullMethod]--
+			l.mu.Unlock()
+			gauge.Dec()
+		})
+	}, nil
+}
@@ -0,0 +1,92 @@
+// Package limit provides interceptors protecting servers from overload with
+// token bucket rate limits, per method concurrency limits and adaptive load
+// shedding by priority. Rejected rpcs fail with ResourceExhausted.
+package limit
+
+import (
+	"path"
+	"strings"
+
+	"github.com/mad01/pingpong/middleware/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// exemptPrefix is the prefix of the methods that are never limited, so that
+// an overloaded server can still report its health.
+const exemptPrefix = "/grpc.health.v1.Health/"
+
+// limitMetrics are the metrics of the limiters sharing a registry.
+type limitMetrics struct {
+	rejected *prometheus.CounterVec
+	inFlight *prometheus.GaugeVec
+	limit    *prometheus.GaugeVec
+}
+
+func newLimitMetrics(reg prometheus.Registerer) *limitMetrics {
+	return &limitMetrics{
+		rejected: metrics.NewCounterVec(reg, prometheus.CounterOpts{
+			Namespace: "grpc",
+			Subsystem: "server",
+			Name:      "rejected_total",
+			Help:      "Total number of rpcs rejected by the server to protect it from overload.",
+		}, []string{"grpc_service", "grpc_method", "reason"}),
+		inFlight: metrics.NewGaugeVec(reg, prometheus.GaugeOpts{
+			Namespace: "grpc",
+			Subsystem: "server",
+			Name:      "in_flight",
+			Help:      "Number of rpcs in flight per method.",
+		}, []string{"grpc_service", "grpc_method"}),
+		limit: metrics.NewGaugeVec(reg, prometheus.GaugeOpts{
+			Namespace: "grpc",
+			Subsystem: "server",
+			Name:      "adaptive_limit",
+			Help:      "Concurrency limit of the adaptive load shedding.",
+		}, []string{"grpc_service"}),
+	}
+}
+
+func (m *limitMetrics) reject(fullMethod, reason string) {
+	service, method := path.Split(fullMethod)
+	m.rejected.WithLabelValues(path.Base(service), method, reason).Inc()
+}
+
+// Limiter admits or rejects rpcs before they reach the handler. The release
+// func returned for admitted rpcs is called once they finished.
+type Limiter interface {
+	Admit(ctx context.Context, fullMethod string) (release func(), err error)
+}
+
+// UnaryServerInterceptor rejects unary rpcs that are not admitted by limiter.
+func UnaryServerInterceptor(limiter Limiter) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if strings.HasPrefix(info.FullMethod, exemptPrefix) {
+			return handler(ctx, req)
+		}
+		release, err := limiter.Admit(ctx, info.FullMethod)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor rejects streams that are not admitted by limiter.
+// A stream holds its admission until it finished.
+func StreamServerInterceptor(limiter Limiter) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if strings.HasPrefix(info.FullMethod, exemptPrefix) {
+			return handler(srv, ss)
+		}
+		release, err := limiter.Admit(ss.Context(), info.FullMethod)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return handler(srv, ss)
+	}
+}
+
+func noop() {}
@@ -0,0 +1,93 @@
+package limit
+
+import (
+	"fmt"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// priorityKey is the metadata key the priority of an rpc is carried in.
+const priorityKey = "x-priority"
+
+// Priority is the priority of an rpc. Lower priority rpcs are shed first
+// when a server is overloaded.
+type Priority int
+
+const (
+	// Low is for rpcs that may be dropped first, e.g. batch or bench traffic.
+	Low Priority = iota
+	// Normal is the priority of rpcs without a priority.
+	Normal
+	// High is for rpcs that are shed last.
+	High
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Low:
+		return "low"
+	case Normal:
+		return "normal"
+	case High:
+		return "high"
+	}
+	return "unknown"
+}
+
+// ParsePriority parses low, normal or high.
+func ParsePriority(name string) (Priority, error) {
+	for p := Low; p <= High; p++ {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return Normal, fmt.Errorf("unknown priority %q", name)
+}
+
+// PriorityFromContext returns the priority in the incoming metadata of ctx,
+// or Normal if it has none.
+func PriorityFromContext(ctx context.Context) Priority {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md[priorityKey]) == 0 {
+		return Normal
+	}
+	p, err := ParsePriority(md[priorityKey][0])
+	if err != nil {
+		return Normal
+	}
+	return p
+}
+
+// withOutgoingPriority returns ctx with p added to its outgoing metadata.
+func withOutgoingPriority(ctx context.Context, p Priority) context.Context {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	md = metadata.Join(md, metadata.Pairs(priorityKey, p.String()))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// outgoingPriority returns the priority of the rpc being handled in ctx, so
+// that calls made on its behalf keep its priority, or else p.
+func outgoingPriority(ctx context.Context, p Priority) Priority {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md[priorityKey]) > 0 {
+		return PriorityFromContext(ctx)
+	}
+	return p
+}
+
+// UnaryClientInterceptor sends the priority of the rpc being handled, or
+// else p, with every unary rpc.
+func UnaryClientInterceptor(p Priority) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withOutgoingPriority(ctx, outgoingPriority(ctx, p)), method, req, reply, cc, opts...)
+	}
+}
+
+// StreamClientInterceptor sends the priority of the rpc being handled, or
+// else p, with every stream.
+func StreamClientInterceptor(p Priority) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withOutgoingPriority(ctx, outgoingPriority(ctx, p)), desc, cc, method, opts...)
+	}
+}
@@ -0,0 +1,82 @@
+package limit
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestParsePriority(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Priority
+		wantErr bool
+	}{
+		{name: "low", want: Low},
+		{name: "normal", want: Normal},
+		{name: "high", want: High},
+		{name: "urgent", want: Normal, wantErr: true},
+		{name: "", want: Normal, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePriority(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePriority(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParsePriority(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPriorityFromContext(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want Priority
+	}{
+		{name: "no metadata", ctx: context.Background(), want: Normal},
+		{name: "no priority", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs("other", "x")), want: Normal},
+		{name: "low", ctx: withPriority(Low), want: Low},
+		{name: "high", ctx: withPriority(High), want: High},
+		{name: "unknown", ctx: metadata.NewIncomingContext(context.Background(), metadata.Pairs(priorityKey, "urgent")), want: Normal},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PriorityFromContext(tt.ctx); got != tt.want {
+				t.Errorf("PriorityFromContext() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{name: "default", ctx: context.Background(), want: "low"},
+		{name: "propagated", ctx: withPriority(High), want: "high"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				md, _ := metadata.FromOutgoingContext(ctx)
+				got = md[priorityKey]
+				return nil
+			}
+			if err := UnaryClientInterceptor(Low)(tt.ctx, "/test/Method", nil, nil, nil, invoker); err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0] != tt.want {
+				t.Errorf("outgoing priority = %v, want [%v]", got, tt.want)
+			}
+		})
+	}
+}
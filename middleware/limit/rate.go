@@ -0,0 +1,162 @@
+package limit
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/mad01/pingpong/middleware/auth"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// sweepInterval is how often the buckets of idle clients are dropped.
+const sweepInterval = time.Minute
+
+// bucket is a token bucket refilled with rate tokens per second up to burst.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token if there is one, or else returns how long it takes
+// until the next token is available.
+func (b *bucket) take(now time.Time, rate float64, burst int) (bool, time.Duration) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > float64(burst) {
+		b.tokens = float64(burst)
+	}
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// refund puts back a token taken by take.
+func (b *bucket) refund() {
+	b.tokens++
+}
+
+// RateLimiter limits the rate of rpcs over all clients and per client. A
+// client is identified by its authenticated identity, or else by the host of
+// its peer address.
+type RateLimiter struct {
+	rate        float64
+	burst       int
+	clientRate  float64
+	clientBurst int
+	// clientIdle is how long it takes for a client bucket to be full again
+	clientIdle time.Duration
+	metrics    *limitMetrics
+
+	mu        sync.Mutex
+	global    bucket
+	clients   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewRateLimiter creates a limiter admitting rate rpcs per second with bursts
+// of up to burst rpcs in total, and clientRate rpcs per second with bursts of
+// up to clientBurst rpcs per client. A rate of 0 disables that limit.
+// Rejections are counted in grpc_server_rejected_total registered with reg.
+func NewRateLimiter(rate float64, burst int, clientRate float64, clientBurst int, reg prometheus.Registerer) *RateLimiter {
+	now := time.Now()
+	var clientIdle time.Duration
+	if clientRate > 0 {
+		clientIdle = time.Duration(float64(clientBurst) / clientRate * float64(time.Second))
+	}
+	return &RateLimiter{
+		rate:        rate,
+		burst:       burst,
+		clientRate:  clientRate,
+		clientBurst: clientBurst,
+		clientIdle:  clientIdle,
+		metrics:     newLimitMetrics(reg),
+		global:      bucket{tokens: float64(burst), last: now},
+		clients:     map[string]*bucket{},
+		lastSweep:   now,
+	}
+}
+
+// Admit takes a token from the client and the global bucket. The client
+// bucket is checked first, so that a client over its own limit does not use
+// up the capacity of the others.
+func (l *RateLimiter) Admit(ctx context.Context, fullMethod string) (func(), error) {
+	client := clientID(ctx)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+
+	var b *bucket
+	if l.clientRate > 0 {
+		l.sweep(now)
+		var ok bool
+		b, ok = l.clients[client]
+		if !ok {
+			b = &bucket{tokens: float64(l.clientBurst), last: now}
+			l.clients[client] = b
+		}
+		if ok, wait := b.take(now, l.clientRate, l.clientBurst); !ok {
+			l.metrics.reject(fullMethod, "client_rate_limit")
+			return nil, exhausted("rate limit exceeded for client "+client, wait)
+		}
+	}
+
+	if l.rate > 0 {
+		if ok, wait := l.global.take(now, l.rate, l.burst); !ok {
+			if b != nil {
+				b.refund()
+			}
+			l.metrics.reject(fullMethod, "rate_limit")
+			return nil, exhausted("rate limit exceeded", wait)
+		}
+	}
+	return noop, nil
+}
+
+// sweep drops the buckets of clients that have been idle long enough for
+// their bucket to be full again, which is the same as a new bucket. It must
+// be called with mu held.
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for client, b := range l.clients {
+		if now.Sub(b.last) >= l.clientIdle {
+			delete(l.clients, client)
+		}
+	}
+}
+
+func clientID(ctx context.Context) string {
+	if identity, ok := auth.IdentityFromContext(ctx); ok {
+		return identity
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return "unknown"
+}
+
+// exhausted returns a ResourceExhausted error telling the client to retry
+// after wait.
+func exhausted(msg string, wait time.Duration) error {
+	st := status.New(codes.ResourceExhausted, msg)
+	if withDetails, err := st.WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(wait)}); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
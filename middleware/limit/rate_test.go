@@ -0,0 +1,144 @@
+package limit
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+)
+
+func TestBucketTake(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name     string
+		tokens   float64
+		elapsed  time.Duration
+		rate     float64
+		burst    int
+		wantOK   bool
+		wantWait time.Duration
+		wantLeft float64
+	}{
+		{name: "full", tokens: 2, rate: 1, burst: 2, wantOK: true, wantLeft: 1},
+		{name: "empty", tokens: 0, rate: 2, burst: 2, wantWait: 500 * time.Millisecond},
+		{name: "partial", tokens: 0.5, rate: 1, burst: 2, wantWait: 500 * time.Millisecond, wantLeft: 0.5},
+		{name: "refilled", tokens: 0, elapsed: time.Second, rate: 1, burst: 2, wantOK: true},
+		{name: "capped at burst", tokens: 0, elapsed: time.Minute, rate: 10, burst: 3, wantOK: true, wantLeft: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &bucket{tokens: tt.tokens, last: start}
+			ok, wait := b.take(start.Add(tt.elapsed), tt.rate, tt.burst)
+			if ok != tt.wantOK || wait != tt.wantWait {
+				t.Errorf("take() = %v, %v, want %v, %v", ok, wait, tt.wantOK, tt.wantWait)
+			}
+			if b.tokens != tt.wantLeft {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.wantLeft)
+			}
+		})
+	}
+}
+
+func fromPeer(host string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(host), Port: 1234},
+	})
+}
+
+func TestRateLimiterAdmit(t *testing.T) {
+	tests := []struct {
+		name        string
+		rate        float64
+		burst       int
+		clientRate  float64
+		clientBurst int
+		clients     []string
+		want        []codes.Code
+	}{
+		{
+			name:    "global burst",
+			rate:    0.001,
+			burst:   2,
+			clients: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3"},
+			want:    []codes.Code{codes.OK, codes.OK, codes.ResourceExhausted},
+		},
+		{
+			name:        "client burst",
+			clientRate:  0.001,
+			clientBurst: 1,
+			clients:     []string{"10.0.0.1", "10.0.0.1", "10.0.0.2"},
+			want:        []codes.Code{codes.OK, codes.ResourceExhausted, codes.OK},
+		},
+		{
+			name:        "client over its limit leaves global capacity to others",
+			rate:        0.001,
+			burst:       2,
+			clientRate:  0.001,
+			clientBurst: 1,
+			clients:     []string{"10.0.0.1", "10.0.0.1", "10.0.0.1", "10.0.0.2"},
+			want:        []codes.Code{codes.OK, codes.ResourceExhausted, codes.ResourceExhausted, codes.OK},
+		},
+		{
+			name:        "global reject refunds the client token",
+			rate:        0.001,
+			burst:       1,
+			clientRate:  0.001,
+			clientBurst: 1,
+			clients:     []string{"10.0.0.1", "10.0.0.2", "10.0.0.2"},
+			want:        []codes.Code{codes.OK, codes.ResourceExhausted, codes.ResourceExhausted},
+		},
+		{
+			name:    "disabled",
+			clients: []string{"10.0.0.1", "10.0.0.1", "10.0.0.1"},
+			want:    []codes.Code{codes.OK, codes.OK, codes.OK},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewRateLimiter(tt.rate, tt.burst, tt.clientRate, tt.clientBurst, prometheus.NewRegistry())
+			for i, client := range tt.clients {
+				_, err := l.Admit(fromPeer(client), "/test/Method")
+				if code := grpc.Code(err); code != tt.want[i] {
+					t.Errorf("rpc %v from %v: code = %v, want %v", i, client, code, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterRefund(t *testing.T) {
+	l := NewRateLimiter(0.001, 1, 0.001, 1, prometheus.NewRegistry())
+	if _, err := l.Admit(fromPeer("10.0.0.1"), "/test/Method"); err != nil {
+		t.Fatalf("first rpc: %v", err)
+	}
+	if _, err := l.Admit(fromPeer("10.0.0.2"), "/test/Method"); grpc.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("second rpc: got %v, want the global limit", err)
+	}
+	if tokens := l.clients["10.0.0.2"].tokens; tokens < 1 {
+		t.Errorf("client tokens after a global reject = %v, want the token back", tokens)
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(0, 0, 10, 5, prometheus.NewRegistry())
+	if l.clientIdle != 500*time.Millisecond {
+		t.Fatalf("clientIdle = %v, want 500ms", l.clientIdle)
+	}
+	now := time.Now()
+	l.clients["idle"] = &bucket{last: now.Add(-time.Second)}
+	l.clients["busy"] = &bucket{last: now.Add(-100 * time.Millisecond)}
+	l.lastSweep = now.Add(-sweepInterval)
+
+	l.sweep(now)
+	if _, ok := l.clients["idle"]; ok {
+		t.Error("idle client bucket was kept")
+	}
+	if _, ok := l.clients["busy"]; !ok {
+		t.Error("busy client bucket was dropped")
+	}
+}
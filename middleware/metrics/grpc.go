@@ -0,0 +1,235 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"path"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+// rpc types as reported in the grpc_type label by grpc_prometheus
+const (
+	unary        = "unary"
+	clientStream = "client_stream"
+	serverStream = "server_stream"
+	bidiStream   = "bidi_stream"
+)
+
+// messageSizeBuckets are the buckets of the message size histograms, from
+// 16B to 1MiB.
+var messageSizeBuckets = prometheus.ExponentialBuckets(16, 4, 9)
+
+// GRPCMetrics records what the grpc_prometheus metrics of a server or client
+// leave out: the sizes of the messages and, optionally, a summary of the
+// handling time. Its interceptors run next to the grpc_prometheus ones.
+type GRPCMetrics struct {
+	msgReceivedBytes *prometheus.HistogramVec
+	msgSentBytes     *prometheus.HistogramVec
+
+	// handlingSummary is nil unless quantiles were given
+	handlingSummary *prometheus.SummaryVec
+}
+
+// NewServerGRPCMetrics creates the metrics of a server registered with reg.
+// A summary of the handling time with the given quantiles, e.g. 0.5, 0.9 and
+// 0.99, is added if any are given.
+func NewServerGRPCMetrics(reg prometheus.Registerer, quantiles ...float64) *GRPCMetrics {
+	return newGRPCMetrics(reg, "server", quantiles)
+}
+
+// NewClientGRPCMetrics creates the metrics of a client registered with reg,
+// with a handling time summary if quantiles are given.
+func NewClientGRPCMetrics(reg prometheus.Registerer, quantiles ...float64) *GRPCMetrics {
+	return newGRPCMetrics(reg, "client", quantiles)
+}
+
+func newGRPCMetrics(reg prometheus.Registerer, side string, quantiles []float64) *GRPCMetrics {
+	m := &GRPCMetrics{
+		msgReceivedBytes: NewHistogramVec(reg, prometheus.HistogramOpts{
+			Namespace: "grpc",
+			Subsystem: side,
+			Name:      "msg_received_bytes",
+			Help:      fmt.Sprintf("Histogram of the size (bytes) of the messages received on the %v.", side),
+			Buckets:   messageSizeBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+		msgSentBytes: NewHistogramVec(reg, prometheus.HistogramOpts{
+			Namespace: "grpc",
+			Subsystem: side,
+			Name:      "msg_sent_bytes",
+			Help:      fmt.Sprintf("Histogram of the size (bytes) of the messages sent by the %v.", side),
+			Buckets:   messageSizeBuckets,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"}),
+	}
+	if len(quantiles) > 0 {
+		// a quantile q is estimated within min(q, 1-q)/10, so the p99 is
+		// accurate to 0.001
+		objectives := make(map[float64]float64, len(quantiles))
+		for _, q := range quantiles {
+			objectives[q] = math.Min(q, 1-q) / 10
+		}
+		m.handlingSummary = NewSummaryVec(reg, prometheus.SummaryOpts{
+			Namespace:  "grpc",
+			Subsystem:  side,
+			Name:       "handling_summary_seconds",
+			Help:       fmt.Sprintf("Summary of the latency (seconds) of RPCs until they were handled on the %v.", side),
+			Objectives: objectives,
+		}, []string{"grpc_type", "grpc_service", "grpc_method"})
+	}
+	return m
+}
+
+// reporter records the metrics of a single rpc.
+type reporter struct {
+	m       *GRPCMetrics
+	rpcType string
+	service string
+	method  string
+	start   time.Time
+}
+
+func (m *GRPCMetrics) newReporter(rpcType, fullMethod string) *reporter {
+	service, method := path.Split(fullMethod)
+	return &reporter{
+		m:       m,
+		rpcType: rpcType,
+		service: path.Base(service),
+		method:  method,
+		start:   time.Now(),
+	}
+}
+
+func (r *reporter) receivedMessage(msg interface{}) {
+	if pm, ok := msg.(proto.Message); ok {
+		r.m.msgReceivedBytes.WithLabelValues(r.rpcType, r.service, r.method).Observe(float64(proto.Size(pm)))
+	}
+}
+
+func (r *reporter) sentMessage(msg interface{}) {
+	if pm, ok := msg.(proto.Message); ok {
+		r.m.msgSentBytes.WithLabelValues(r.rpcType, r.service, r.method).Observe(float64(proto.Size(pm)))
+	}
+}
+
+func (r *reporter) handled() {
+	if r.m.handlingSummary != nil {
+		r.m.handlingSummary.WithLabelValues(r.rpcType, r.service, r.method).Observe(time.Since(r.start).Seconds())
+	}
+}
+
+func methodType(isClientStream, isServerStream bool) string {
+	switch {
+	case isClientStream && isServerStream:
+		return bidiStream
+	case isClientStream:
+		return clientStream
+	case isServerStream:
+		return serverStream
+	}
+	return unary
+}
+
+// UnaryServerInterceptor records the metrics of unary rpcs on a server.
+func (m *GRPCMetrics) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r := m.newReporter(unary, info.FullMethod)
+		r.receivedMessage(req)
+		resp, err := handler(ctx, req)
+		r.handled()
+		if err == nil {
+			r.sentMessage(resp)
+		}
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor records the metrics of streams and their messages
+// on a server.
+func (m *GRPCMetrics) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		r := m.newReporter(methodType(info.IsClientStream, info.IsServerStream), info.FullMethod)
+		err := handler(srv, &monitoredServerStream{ServerStream: ss, reporter: r})
+		r.handled()
+		return err
+	}
+}
+
+// UnaryClientInterceptor records the metrics of unary rpcs on a client.
+func (m *GRPCMetrics) UnaryClientInterceptor() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		r := m.newReporter(unary, method)
+		r.sentMessage(req)
+		err := invoker(ctx, method, req, reply, cc, opts...)
+		if err == nil {
+			r.receivedMessage(reply)
+		}
+		r.handled()
+		return err
+	}
+}
+
+// StreamClientInterceptor records the metrics of streams and their messages
+// on a client. A stream is handled once it ended with an error or io.EOF, or
+// failed to open.
+func (m *GRPCMetrics) StreamClientInterceptor() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		r := m.newReporter(methodType(desc.ClientStreams, desc.ServerStreams), method)
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			r.handled()
+			return nil, err
+		}
+		return &monitoredClientStream{ClientStream: cs, reporter: r}, nil
+	}
+}
+
+// monitoredServerStream records the sizes of the messages of a stream.
+type monitoredServerStream struct {
+	grpc.ServerStream
+	reporter *reporter
+}
+
+func (s *monitoredServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.reporter.sentMessage(m)
+	}
+	return err
+}
+
+func (s *monitoredServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.reporter.receivedMessage(m)
+	}
+	return err
+}
+
+// monitoredClientStream records the sizes of the messages of a stream.
+type monitoredClientStream struct {
+	grpc.ClientStream
+	reporter *reporter
+}
+
+func (s *monitoredClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err == nil {
+		s.reporter.sentMessage(m)
+	}
+	return err
+}
+
+func (s *monitoredClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		// the stream ended with io.EOF or an error
+		s.reporter.handled()
+		return err
+	}
+	s.reporter.receivedMessage(m)
+	return nil
+}
@@ -0,0 +1,112 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/duration"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// gather returns the metric family name of reg, or nil if it has none.
+func gather(t *testing.T, reg *prometheus.Registry, name string) *dto.MetricFamily {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return family
+		}
+	}
+	return nil
+}
+
+// sampleSum returns the sample count and sum of the single histogram or
+// summary of family.
+func sampleSum(family *dto.MetricFamily) (uint64, float64) {
+	if family == nil || len(family.Metric) != 1 {
+		return 0, 0
+	}
+	m := family.Metric[0]
+	if m.Histogram != nil {
+		return m.Histogram.GetSampleCount(), m.Histogram.GetSampleSum()
+	}
+	return m.Summary.GetSampleCount(), m.Summary.GetSampleSum()
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	req := &duration.Duration{Seconds: 1}
+	resp := &duration.Duration{Seconds: 1000, Nanos: 1}
+	tests := []struct {
+		name      string
+		quantiles []float64
+		err       error
+		wantSent  uint64
+		summary   bool
+	}{
+		{name: "ok", wantSent: 1},
+		{name: "failed", err: status.Error(codes.Internal, "failed")},
+		{name: "summary", quantiles: []float64{0.5, 0.99}, wantSent: 1, summary: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := prometheus.NewRegistry()
+			m := NewServerGRPCMetrics(reg, tt.quantiles...)
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return resp, nil
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+			if _, err := m.UnaryServerInterceptor()(context.Background(), req, info, handler); err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			received := gather(t, reg, "grpc_server_msg_received_bytes")
+			if count, sum := sampleSum(received); count != 1 || sum != float64(proto.Size(req)) {
+				t.Errorf("received bytes = %v samples of %v, want 1 of %v", count, sum, proto.Size(req))
+			}
+			if labels := received.Metric[0].GetLabel(); labels[1].GetValue() != "test.Service" || labels[2].GetValue() != "unary" {
+				t.Errorf("labels = %v", labels)
+			}
+			sent := gather(t, reg, "grpc_server_msg_sent_bytes")
+			if count, _ := sampleSum(sent); count != tt.wantSent {
+				t.Errorf("sent bytes samples = %v, want %v", count, tt.wantSent)
+			}
+			summary := gather(t, reg, "grpc_server_handling_summary_seconds")
+			if (summary != nil) != tt.summary {
+				t.Fatalf("summary exported = %v, want %v", summary != nil, tt.summary)
+			}
+			if summary != nil {
+				if n := len(summary.Metric[0].Summary.Quantile); n != len(tt.quantiles) {
+					t.Errorf("summary has %v quantiles, want %v", n, len(tt.quantiles))
+				}
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	first := NewClientGRPCMetrics(reg, 0.5)
+	second := NewClientGRPCMetrics(reg, 0.5)
+	if first.msgSentBytes != second.msgSentBytes || first.handlingSummary != second.handlingSummary {
+		t.Error("metrics of a second client were not shared with the first")
+	}
+
+	counter := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test counter."})
+	if c := Register(reg, counter); c != counter {
+		t.Error("Register() did not return the new collector")
+	}
+	again := prometheus.NewCounter(prometheus.CounterOpts{Name: "test_total", Help: "Test counter."})
+	if c := Register(reg, again); c != counter {
+		t.Error("Register() did not return the registered collector")
+	}
+}
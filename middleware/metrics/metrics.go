@@ -0,0 +1,53 @@
+// Package metrics helps middleware to register its prometheus collectors
+// with a given registry rather than the global one, so that services running
+// in the same process export their metrics separately.
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NewCounterVec creates a counter vec and registers it with reg. If reg
+// already has a counter vec of the same name and labels, that one is
+// returned instead, so that every instance of a middleware sharing a
+// registry counts into the same metric.
+func NewCounterVec(reg prometheus.Registerer, opts prometheus.CounterOpts, labels []string) *prometheus.CounterVec {
+	return Register(reg, prometheus.NewCounterVec(opts, labels)).(*prometheus.CounterVec)
+}
+
+// NewGaugeVec creates a gauge vec and registers it with reg, or returns the
+// one already registered with reg.
+func NewGaugeVec(reg prometheus.Registerer, opts prometheus.GaugeOpts, labels []string) *prometheus.GaugeVec {
+	return Register(reg, prometheus.NewGaugeVec(opts, labels)).(*prometheus.GaugeVec)
+}
+
+// NewHistogramVec creates a histogram vec and registers it with reg, or
+// returns the one already registered with reg.
+func NewHistogramVec(reg prometheus.Registerer, opts prometheus.HistogramOpts, labels []string) *prometheus.HistogramVec {
+	return Register(reg, prometheus.NewHistogramVec(opts, labels)).(*prometheus.HistogramVec)
+}
+
+// NewSummaryVec creates a summary vec and registers it with reg, or returns
+// the one already registered with reg.
+func NewSummaryVec(reg prometheus.Registerer, opts prometheus.SummaryOpts, labels []string) *prometheus.SummaryVec {
+	return Register(reg, prometheus.NewSummaryVec(opts, labels)).(*prometheus.SummaryVec)
+}
+
+// NewHistogram creates a histogram without labels and registers it with reg,
+// or returns the one already registered with reg.
+func NewHistogram(reg prometheus.Registerer, opts prometheus.HistogramOpts) prometheus.Histogram {
+	return Register(reg, prometheus.NewHistogram(opts)).(prometheus.Histogram)
+}
+
+// Register registers c with reg and returns it. If reg already has an equal
+// collector, e.g. the grpc client metrics of another connection, that one is
+// returned instead.
+func Register(reg prometheus.Registerer, c prometheus.Collector) prometheus.Collector {
+	if err := reg.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
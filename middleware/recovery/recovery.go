@@ -0,0 +1,89 @@
+// Package recovery provides interceptors turning panics in rpc handlers into
+// Internal errors instead of crashing the process.
+package recovery
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
+	"github.com/mad01/pingpong/middleware/metrics"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func newPanicsTotal(reg prometheus.Registerer) *prometheus.CounterVec {
+	return metrics.NewCounterVec(reg, prometheus.CounterOpts{
+		Namespace: "grpc",
+		Subsystem: "server",
+		Name:      "panics_recovered_total",
+		Help:      "Total number of panics recovered in rpcs on the server.",
+	}, []string{"grpc_service", "grpc_method"})
+}
+
+// UnaryServerInterceptor recovers panics in the rest of the chain and returns
+// them as an Internal error. The panic and its stack are logged with the
+// request logger if the interceptor runs after grpc_zap, or with logger
+// otherwise, the span in the context is marked as failed and the panic is
+// counted in grpc_server_panics_recovered_total registered with reg.
+//
+// The interceptor is meant to run both first in the chain, to survive panics
+// in other interceptors, and last, so that the Internal error is logged,
+// traced and counted by the interceptors in between like any other error.
+func UnaryServerInterceptor(logger *zap.Logger, reg prometheus.Registerer) grpc.UnaryServerInterceptor {
+	panicsTotal := newPanicsTotal(reg)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (_ interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ctx, logger, panicsTotal, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor is the stream counterpart of
+// UnaryServerInterceptor.
+func StreamServerInterceptor(logger *zap.Logger, reg prometheus.Registerer) grpc.StreamServerInterceptor {
+	panicsTotal := newPanicsTotal(reg)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(ss.Context(), logger, panicsTotal, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recovered(ctx context.Context, logger *zap.Logger, panicsTotal *prometheus.CounterVec, fullMethod string, r interface{}) error {
+	service, method := path.Split(fullMethod)
+	service = path.Base(service)
+	panicsTotal.WithLabelValues(service, method).Inc()
+
+	msg := fmt.Sprint(r)
+	requestLogger := grpc_zap.Extract(ctx)
+	if !requestLogger.Core().Enabled(zapcore.ErrorLevel) {
+		// no request logger in the context
+		requestLogger = logger.With(
+			zap.String("grpc.service", service),
+			zap.String("grpc.method", method),
+		)
+	}
+	requestLogger.Error("recovered from panic", zap.String("panic", msg), zap.Stack("stacktrace"))
+
+	if span := opentracing.SpanFromContext(ctx); span != nil {
+		ext.Error.Set(span, true)
+		span.LogFields(log.String("event", "panic"), log.String("message", msg))
+	}
+
+	return status.Errorf(codes.Internal, "panic: %v", msg)
+}
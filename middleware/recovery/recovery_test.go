@@ -0,0 +1,156 @@
+package recovery
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// testSpan records the tags and logs set on a noop span.
+type testSpan struct {
+	opentracing.Span
+	tags map[string]interface{}
+	logs []log.Field
+}
+
+func newTestSpan() *testSpan {
+	return &testSpan{Span: opentracing.NoopTracer{}.StartSpan("test"), tags: map[string]interface{}{}}
+}
+
+func (s *testSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.tags[key] = value
+	return s
+}
+
+func (s *testSpan) LogFields(fields ...log.Field) {
+	s.logs = append(s.logs, fields...)
+}
+
+func newTestLogger() (*zap.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.DebugLevel)
+	return zap.New(core), &buf
+}
+
+func panicsRecovered(t *testing.T, reg *prometheus.Registry) float64 {
+	families, err := reg.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var total float64
+	for _, family := range families {
+		if family.GetName() != "grpc_server_panics_recovered_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			total += m.GetCounter().GetValue()
+		}
+	}
+	return total
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler grpc.UnaryHandler
+		code    codes.Code
+		panics  float64
+	}{
+		{
+			name:    "no panic",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) { return "pong", nil },
+			code:    codes.OK,
+		},
+		{
+			name: "error",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+				return nil, grpc.Errorf(codes.NotFound, "not found")
+			},
+			code: codes.NotFound,
+		},
+		{
+			name:    "panic",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) { panic("boom") },
+			code:    codes.Internal,
+			panics:  1,
+		},
+		{
+			name: "nil map panic",
+			handler: func(ctx context.Context, req interface{}) (interface{}, error) {
+				var m map[string]int
+				m["boom"]++
+				return nil, nil
+			},
+			code:   codes.Internal,
+			panics: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger, logs := newTestLogger()
+			reg := prometheus.NewRegistry()
+			span := newTestSpan()
+			ctx := opentracing.ContextWithSpan(context.Background(), span)
+			info := &grpc.UnaryServerInfo{FullMethod: "/com.Pinger/Ping"}
+
+			_, err := UnaryServerInterceptor(logger, reg)(ctx, nil, info, tt.handler)
+			if code := grpc.Code(err); code != tt.code {
+				t.Errorf("code = %v, want %v", code, tt.code)
+			}
+			if got := panicsRecovered(t, reg); got != tt.panics {
+				t.Errorf("panics recovered = %v, want %v", got, tt.panics)
+			}
+
+			if tt.panics == 0 {
+				if logs.Len() != 0 {
+					t.Errorf("logged %q without a panic", logs.String())
+				}
+				return
+			}
+			if !strings.Contains(logs.String(), "recovered from panic") || !strings.Contains(logs.String(), `"grpc.method":"Ping"`) {
+				t.Errorf("log %q does not contain the panic", logs.String())
+			}
+			if span.tags["error"] != true {
+				t.Errorf("span not marked as failed, tags %v", span.tags)
+			}
+		})
+	}
+}
+
+type testServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *testServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	logger, _ := newTestLogger()
+	reg := prometheus.NewRegistry()
+	interceptor := StreamServerInterceptor(logger, reg)
+	ss := &testServerStream{ctx: context.Background()}
+	info := &grpc.StreamServerInfo{FullMethod: "/com.Pinger/PingPong"}
+
+	err := interceptor(nil, ss, info, func(srv interface{}, stream grpc.ServerStream) error { panic("boom") })
+	if code := grpc.Code(err); code != codes.Internal {
+		t.Errorf("code = %v, want Internal", code)
+	}
+	err = interceptor(nil, ss, info, func(srv interface{}, stream grpc.ServerStream) error { return nil })
+	if err != nil {
+		t.Errorf("err = %v, want nil", err)
+	}
+	if got := panicsRecovered(t, reg); got != 1 {
+		t.Errorf("panics recovered = %v, want 1", got)
+	}
+}
@@ -0,0 +1,46 @@
+package retry
+
+import "sync"
+
+// Budget limits retries across calls so that retries don't multiply the load
+// on a failing server. It is a token bucket following the grpc retry
+// throttling design: every retryable failure takes a token, every successful
+// call puts back ratio tokens, and retries are only allowed while more than
+// half of the tokens are left.
+type Budget struct {
+	mu        sync.Mutex
+	maxTokens float64
+	ratio     float64
+	tokens    float64
+}
+
+// NewBudget creates a full budget of maxTokens tokens.
+func NewBudget(maxTokens, ratio float64) *Budget {
+	return &Budget{
+		maxTokens: maxTokens,
+		ratio:     ratio,
+		tokens:    maxTokens,
+	}
+}
+
+// failed takes a token for a retryable failure and reports whether the call
+// may be retried.
+func (b *Budget) failed() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens--
+	if b.tokens < 0 {
+		b.tokens = 0
+	}
+	return b.tokens > b.maxTokens/2
+}
+
+// succeeded puts back ratio tokens for a successful call.
+func (b *Budget) succeeded() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens += b.ratio
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+}
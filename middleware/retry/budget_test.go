@@ -0,0 +1,72 @@
+package retry
+
+import "testing"
+
+func TestBudget(t *testing.T) {
+	tests := []struct {
+		name      string
+		maxTokens float64
+		ratio     float64
+		// outcomes of the calls in order, true for success
+		calls []bool
+		// whether the last failure may be retried
+		want bool
+	}{
+		{name: "full budget", maxTokens: 10, ratio: 0.1, calls: []bool{false}, want: true},
+		{name: "down to half", maxTokens: 10, ratio: 0.1, calls: []bool{false, false, false, false}, want: true},
+		{name: "at half", maxTokens: 10, ratio: 0.1, calls: []bool{false, false, false, false, false}, want: false},
+		{name: "exhausted", maxTokens: 10, ratio: 0.1, calls: repeat(false, 20), want: false},
+		{
+			name:      "refilled by successes",
+			maxTokens: 10,
+			ratio:     1,
+			calls:     append(append(repeat(false, 5), repeat(true, 2)...), false),
+			want:      true,
+		},
+		{
+			name:      "partly refilled",
+			maxTokens: 10,
+			ratio:     0.1,
+			calls:     append(append(repeat(false, 5), repeat(true, 5)...), false),
+			want:      false,
+		},
+		{
+			name:      "successes don't exceed max",
+			maxTokens: 4,
+			ratio:     1,
+			calls:     append(repeat(true, 10), false, false),
+			want:      false,
+		},
+		{
+			name:      "empty budget refills from zero",
+			maxTokens: 4,
+			ratio:     1,
+			calls:     append(append(repeat(false, 10), repeat(true, 3)...), false),
+			want:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBudget(tt.maxTokens, tt.ratio)
+			var got bool
+			for _, success := range tt.calls {
+				if success {
+					b.succeeded()
+				} else {
+					got = b.failed()
+				}
+			}
+			if got != tt.want {
+				t.Errorf("retry allowed = %v, want %v (tokens %v)", got, tt.want, b.tokens)
+			}
+		})
+	}
+}
+
+func repeat(v bool, n int) []bool {
+	out := make([]bool, n)
+	for i := range out {
+		out[i] = v
+	}
+	return out
+}
@@ -0,0 +1,249 @@
+// Package retry provides a client interceptor retrying failed unary rpcs
+// with exponential backoff, limited by a retry budget.
+package retry
+
+import (
+	"math/rand"
+	"path"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/mad01/pingpong/middleware/metrics"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type options struct {
+	maxAttempts       int
+	perAttemptTimeout time.Duration
+	backoffBase       time.Duration
+	backoffMax        time.Duration
+	codes             []codes.Code
+	budget            *Budget
+	tracer            opentracing.Tracer
+	registerer        prometheus.Registerer
+}
+
+func defaultOptions() options {
+	return options{
+		maxAttempts: 3,
+		backoffBase: 50 * time.Millisecond,
+		backoffMax:  time.Second,
+		codes:       []codes.Code{codes.Unavailable, codes.ResourceExhausted},
+		tracer:      opentracing.NoopTracer{},
+		registerer:  prometheus.NewRegistry(),
+	}
+}
+
+// Option configures the retry interceptor.
+type Option func(*options)
+
+// WithMaxAttempts sets the number of attempts including the first one.
+func WithMaxAttempts(n int) Option {
+	return func(o *options) {
+		o.maxAttempts = n
+	}
+}
+
+// WithPerAttemptTimeout bounds every attempt by timeout, within the deadline
+// of the call. Attempts running into the timeout are retried.
+func WithPerAttemptTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.perAttemptTimeout = timeout
+	}
+}
+
+// WithBackoff sets the backoff between attempts. The n-th retry waits a
+// random duration up to base*2^(n-1), or longer if the server asked for it
+// with a RetryInfo error detail, capped at max.
+func WithBackoff(base, max time.Duration) Option {
+	return func(o *options) {
+		o.backoffBase = base
+		o.backoffMax = max
+	}
+}
+
+// WithCodes sets the status codes that are retried.
+func WithCodes(retryable ...codes.Code) Option {
+	return func(o *options) {
+		o.codes = retryable
+	}
+}
+
+// WithBudget limits retries with budget, which is usually shared by all
+// calls on a connection. Retries are not limited by default.
+func WithBudget(budget *Budget) Option {
+	return func(o *options) {
+		o.budget = budget
+	}
+}
+
+// WithTracer sets the tracer used to start a span around all attempts of a
+// call, tagged with the number of attempts.
+func WithTracer(tracer opentracing.Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithRegisterer sets the registry the retry metrics are registered with. By
+// default they are registered with a private registry and not exported.
+func WithRegisterer(reg prometheus.Registerer) Option {
+	return func(o *options) {
+		o.registerer = reg
+	}
+}
+
+// UnaryClientInterceptor retries unary rpcs failing with a retryable code.
+// It is meant to run before the tracing interceptor, so that every attempt
+// gets its own span as a child of the span around the call.
+func UnaryClientInterceptor(opts ...Option) grpc.UnaryClientInterceptor {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	retriesTotal := metrics.NewCounterVec(o.registerer, prometheus.CounterOpts{
+		Namespace: "grpc",
+		Subsystem: "client",
+		Name:      "retries_total",
+		Help:      "Total number of rpcs retried by the client, by the code of the failed attempt.",
+	}, []string{"grpc_service", "grpc_method", "grpc_code"})
+	retriesThrottledTotal := metrics.NewCounterVec(o.registerer, prometheus.CounterOpts{
+		Namespace: "grpc",
+		Subsystem: "client",
+		Name:      "retries_throttled_total",
+		Help:      "Total number of retries skipped by the client because the retry budget was exhausted.",
+	}, []string{"grpc_service", "grpc_method"})
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		var parentCtx opentracing.SpanContext
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			parentCtx = parent.Context()
+		}
+		span := o.tracer.StartSpan(method, opentracing.ChildOf(parentCtx))
+		defer span.Finish()
+		ctx = opentracing.ContextWithSpan(ctx, span)
+
+		service, name := path.Split(method)
+		service = path.Base(service)
+
+		var err error
+		attempt := 1
+		for ; ; attempt++ {
+			err = o.invoke(ctx, method, req, reply, cc, invoker, callOpts...)
+			if err == nil {
+				if o.budget != nil {
+					o.budget.succeeded()
+				}
+				break
+			}
+
+			code := grpc.Code(err)
+			if !o.retryable(ctx, code) {
+				break
+			}
+			if o.budget != nil && !o.budget.failed() {
+				retriesThrottledTotal.WithLabelValues(service, name).Inc()
+				span.LogFields(log.String("event", "retry throttled"))
+				break
+			}
+			if attempt >= o.maxAttempts {
+				break
+			}
+
+			delay, ok := o.backoff(ctx, attempt, err)
+			if !ok {
+				span.LogFields(log.String("event", "retry past deadline"))
+				break
+			}
+			retriesTotal.WithLabelValues(service, name, code.String()).Inc()
+			span.LogFields(
+				log.String("event", "retry"),
+				log.Int("attempt", attempt),
+				log.String("code", code.String()),
+				log.String("backoff", delay.String()),
+			)
+
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				span.SetTag("retry.attempts", attempt)
+				ext.Error.Set(span, true)
+				return err
+			case <-timer.C:
+			}
+		}
+
+		span.SetTag("retry.attempts", attempt)
+		if err != nil {
+			ext.Error.Set(span, true)
+		}
+		return err
+	}
+}
+
+func (o *options) invoke(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+	if o.perAttemptTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.perAttemptTimeout)
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, callOpts...)
+}
+
+// retryable reports whether a failed attempt with code should be retried.
+func (o *options) retryable(ctx context.Context, code codes.Code) bool {
+	if ctx.Err() != nil {
+		// the call itself is canceled or past its deadline
+		return false
+	}
+	if code == codes.DeadlineExceeded && o.perAttemptTimeout > 0 {
+		// only the attempt ran into its timeout
+		return true
+	}
+	for _, c := range o.codes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// backoff returns the time to wait before retrying after the given attempt
+// failed with err. A delay asked for by the server is capped at backoffMax
+// like the computed one. It reports false if the call's deadline expires
+// before the retry could be made.
+func (o *options) backoff(ctx context.Context, attempt int, err error) (time.Duration, bool) {
+	max := o.backoffBase << uint(attempt-1)
+	if max > o.backoffMax || max <= 0 {
+		max = o.backoffMax
+	}
+	delay := time.Duration(rand.Int63n(int64(max) + 1))
+
+	if st, ok := status.FromError(err); ok {
+		for _, detail := range st.Details() {
+			if info, ok := detail.(*errdetails.RetryInfo); ok {
+				if d, err := ptypes.Duration(info.RetryDelay); err == nil && d > delay {
+					delay = d
+				}
+			}
+		}
+	}
+	if delay > o.backoffMax {
+		delay = o.backoffMax
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && delay >= time.Until(deadline) {
+		return 0, false
+	}
+	return delay, true
+}
@@ -0,0 +1,209 @@
+package retry
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func withRetryInfo(t *testing.T, code codes.Code, delay time.Duration) error {
+	st, err := status.New(code, "retry later").WithDetails(&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(delay)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+func TestBackoff(t *testing.T) {
+	o := defaultOptions()
+	o.backoffBase = 10 * time.Millisecond
+	o.backoffMax = 100 * time.Millisecond
+	unavailable := status.Error(codes.Unavailable, "unavailable")
+
+	tests := []struct {
+		name     string
+		attempt  int
+		err      error
+		timeout  time.Duration
+		min, max time.Duration
+		ok       bool
+	}{
+		{name: "first retry", attempt: 1, err: unavailable, max: 10 * time.Millisecond, ok: true},
+		{name: "third retry", attempt: 3, err: unavailable, max: 40 * time.Millisecond, ok: true},
+		{name: "capped", attempt: 10, err: unavailable, max: 100 * time.Millisecond, ok: true},
+		{name: "overflow", attempt: 70, err: unavailable, max: 100 * time.Millisecond, ok: true},
+		{
+			name:    "server delay",
+			attempt: 1,
+			err:     withRetryInfo(t, codes.ResourceExhausted, 50*time.Millisecond),
+			min:     50 * time.Millisecond,
+			max:     50 * time.Millisecond,
+			ok:      true,
+		},
+		{
+			name:    "server delay capped",
+			attempt: 1,
+			err:     withRetryInfo(t, codes.ResourceExhausted, time.Hour),
+			min:     100 * time.Millisecond,
+			max:     100 * time.Millisecond,
+			ok:      true,
+		},
+		{
+			name:    "within deadline",
+			attempt: 1,
+			err:     withRetryInfo(t, codes.ResourceExhausted, 50*time.Millisecond),
+			timeout: time.Second,
+			min:     50 * time.Millisecond,
+			max:     50 * time.Millisecond,
+			ok:      true,
+		},
+		{
+			name:    "past deadline",
+			attempt: 1,
+			err:     withRetryInfo(t, codes.ResourceExhausted, 50*time.Millisecond),
+			timeout: 20 * time.Millisecond,
+			ok:      false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, tt.timeout)
+				defer cancel()
+			}
+			for i := 0; i < 100; i++ {
+				delay, ok := o.backoff(ctx, tt.attempt, tt.err)
+				if ok != tt.ok {
+					t.Fatalf("ok = %v, want %v", ok, tt.ok)
+				}
+				if ok && (delay < tt.min || delay > tt.max) {
+					t.Fatalf("delay = %v, want between %v and %v", delay, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestUnaryClientInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		errs     []error
+		attempts int
+		code     codes.Code
+	}{
+		{name: "success", errs: []error{nil}, attempts: 1, code: codes.OK},
+		{
+			name:     "retried until success",
+			errs:     []error{status.Error(codes.Unavailable, ""), status.Error(codes.Unavailable, ""), nil},
+			attempts: 3,
+			code:     codes.OK,
+		},
+		{
+			name:     "max attempts",
+			errs:     []error{status.Error(codes.Unavailable, ""), status.Error(codes.Unavailable, ""), status.Error(codes.Unavailable, ""), nil},
+			attempts: 3,
+			code:     codes.Unavailable,
+		},
+		{
+			name:     "not retryable",
+			errs:     []error{status.Error(codes.InvalidArgument, ""), nil},
+			attempts: 1,
+			code:     codes.InvalidArgument,
+		},
+		{
+			name:     "custom codes",
+			opts:     []Option{WithCodes(codes.Internal)},
+			errs:     []error{status.Error(codes.Internal, ""), nil},
+			attempts: 2,
+			code:     codes.OK,
+		},
+		{
+			name:     "throttled by budget",
+			opts:     []Option{WithBudget(NewBudget(2, 0.1))},
+			errs:     []error{status.Error(codes.Unavailable, ""), nil},
+			attempts: 1,
+			code:     codes.Unavailable,
+		},
+		{
+			name: "server delay past deadline",
+			opts: []Option{WithBackoff(time.Millisecond, time.Hour)},
+			errs: []error{
+				withRetryInfo(t, codes.ResourceExhausted, time.Minute),
+				nil,
+			},
+			attempts: 1,
+			code:     codes.ResourceExhausted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{
+				WithBackoff(time.Millisecond, 5*time.Millisecond),
+				WithRegisterer(prometheus.NewRegistry()),
+			}, tt.opts...)
+			interceptor := UnaryClientInterceptor(opts...)
+
+			attempts := 0
+			invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err := interceptor(ctx, "/com.RandomMsg/GetRandomMsg", nil, nil, nil, invoker)
+			if code := grpc.Code(err); code != tt.code {
+				t.Errorf("code = %v, want %v", code, tt.code)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("attempts = %v, want %v", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestPerAttemptTimeout(t *testing.T) {
+	interceptor := UnaryClientInterceptor(
+		WithPerAttemptTimeout(10*time.Millisecond),
+		WithBackoff(time.Millisecond, time.Millisecond),
+		WithRegisterer(prometheus.NewRegistry()),
+	)
+	attempts := 0
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		attempts++
+		if attempts == 1 {
+			<-ctx.Done()
+			return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+		}
+		return nil
+	}
+	if err := interceptor(context.Background(), "/com.RandomMsg/GetRandomMsg", nil, nil, nil, invoker); err != nil {
+		t.Errorf("err = %v, want the timed out attempt to be retried", err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %v, want 2", attempts)
+	}
+}
+
+func TestDefaultRegisterer(t *testing.T) {
+	UnaryClientInterceptor()
+
+	// registering the same metric with the global registry fails if the
+	// interceptor registered it there
+	retries := prometheus.NewCounter(prometheus.CounterOpts{Name: "grpc_client_retries_total", Help: "test"})
+	if err := prometheus.Register(retries); err != nil {
+		t.Fatalf("retry metrics registered with the global registry: %v", err)
+	}
+	prometheus.Unregister(retries)
+}
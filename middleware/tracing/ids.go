@@ -0,0 +1,58 @@
+package tracing
+
+import (
+	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// TraceIDTrailer is the trailer the trace id of an rpc is returned in, if
+// its trace was sampled.
+const TraceIDTrailer = "x-trace-id"
+
+// UnaryServerIDInterceptor tags the request with the jaeger trace and span id
+// of its span, so that they are logged with every request log line, and
+// returns the trace id to the caller in the x-trace-id trailer. It runs
+// after the interceptor starting the span.
+func UnaryServerIDInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if traceID, ok := tagIDs(ctx); ok {
+			grpc.SetTrailer(ctx, metadata.Pairs(TraceIDTrailer, traceID))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerIDInterceptor tags streams with the ids of their span and
+// returns the trace id in the x-trace-id trailer.
+func StreamServerIDInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if traceID, ok := tagIDs(ss.Context()); ok {
+			ss.SetTrailer(metadata.Pairs(TraceIDTrailer, traceID))
+		}
+		return handler(srv, ss)
+	}
+}
+
+// tagIDs sets the trace.id, trace.span_id and trace.sampled tags from the
+// jaeger span of ctx, and returns the trace id if the trace is sampled.
+func tagIDs(ctx context.Context) (string, bool) {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return "", false
+	}
+	sc, ok := span.Context().(jaeger.SpanContext)
+	if !ok || !sc.IsValid() {
+		return "", false
+	}
+
+	traceID := sc.TraceID().String()
+	grpc_ctxtags.Extract(ctx).
+		Set("trace.id", traceID).
+		Set("trace.span_id", sc.SpanID().String()).
+		Set("trace.sampled", sc.IsSampled())
+	return traceID, sc.IsSampled()
+}
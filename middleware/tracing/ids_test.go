@@ -0,0 +1,238 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
+	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/uber/jaeger-client-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+)
+
+// newTestTracer returns a jaeger tracer sampling all or no traces. Its spans
+// are kept in memory, so there is nothing to close.
+func newTestTracer(sampled bool) opentracing.Tracer {
+	tracer, _ := jaeger.NewTracer("test", jaeger.NewConstSampler(sampled), jaeger.NewInMemoryReporter())
+	return tracer
+}
+
+// withTags runs fn with a context holding the grpc_ctxtags of a request, as
+// set up by the server interceptors.
+func withTags(fn func(ctx context.Context)) {
+	grpc_ctxtags.UnaryServerInterceptor()(context.Background(), nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		fn(ctx)
+		return nil, nil
+	})
+}
+
+func TestTagIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracer  func() opentracing.Tracer
+		tagged  bool
+		sampled bool
+	}{
+		{
+			name:    "sampled",
+			tracer:  func() opentracing.Tracer { return newTestTracer(true) },
+			tagged:  true,
+			sampled: true,
+		},
+		{
+			name:   "unsampled",
+			tracer: func() opentracing.Tracer { return newTestTracer(false) },
+			tagged: true,
+		},
+		{
+			name:   "noop",
+			tracer: func() opentracing.Tracer { return opentracing.NoopTracer{} },
+		},
+		{
+			name: "no span",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			withTags(func(ctx context.Context) {
+				var sc jaeger.SpanContext
+				if tc.tracer != nil {
+					span := tc.tracer().StartSpan("test")
+					defer span.Finish()
+					ctx = opentracing.ContextWithSpan(ctx, span)
+					sc, _ = span.Context().(jaeger.SpanContext)
+				}
+
+				traceID, ok := tagIDs(ctx)
+				if ok != tc.sampled {
+					t.Errorf("got sampled %v, want %v", ok, tc.sampled)
+				}
+				if tc.sampled && traceID != sc.TraceID().String() {
+					t.Errorf("got trace id %q, want %q", traceID, sc.TraceID().String())
+				}
+
+				values := grpc_ctxtags.Extract(ctx).Values()
+				if !tc.tagged {
+					if len(values) != 0 {
+						t.Errorf("got tags %v, want none", values)
+					}
+					return
+				}
+				if values["trace.id"] != sc.TraceID().String() {
+					t.Errorf("got trace.id %v, want %v", values["trace.id"], sc.TraceID())
+				}
+				if values["trace.span_id"] != sc.SpanID().String() {
+					t.Errorf("got trace.span_id %v, want %v", values["trace.span_id"], sc.SpanID())
+				}
+				if values["trace.sampled"] != tc.sampled {
+					t.Errorf("got trace.sampled %v, want %v", values["trace.sampled"], tc.sampled)
+				}
+			})
+		})
+	}
+}
+
+func TestUnaryServerIDInterceptorLogsIDs(t *testing.T) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.DebugLevel)
+	logger := zap.New(core)
+
+	interceptor := grpc_middleware.ChainUnaryServer(
+		grpc_ctxtags.UnaryServerInterceptor(),
+		otgrpc.OpenTracingServerInterceptor(newTestTracer(true)),
+		UnaryServerIDInterceptor(),
+		grpc_zap.UnaryServerInterceptor(logger),
+	)
+	var traceID string
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		traceID = opentracing.SpanFromContext(ctx).Context().(jaeger.SpanContext).TraceID().String()
+		grpc_zap.Extract(ctx).Info("handling")
+		return nil, grpc.Errorf(codes.Internal, "broken")
+	}
+	interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: "/com.Pinger/Ping"}, handler)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	if len(lines) != 2 {
+		t.Fatalf("got %v log lines, want 2: %s", len(lines), buf.String())
+	}
+	for _, line := range lines {
+		var fields map[string]interface{}
+		if err := json.Unmarshal(line, &fields); err != nil {
+			t.Fatal(err)
+		}
+		if fields["trace.id"] != traceID {
+			t.Errorf("got trace.id %v in %v, want %v", fields["trace.id"], fields["msg"], traceID)
+		}
+		if fields["trace.span_id"] == nil || fields["trace.sampled"] != true {
+			t.Errorf("no span id or sampled field in %v: %s", fields["msg"], line)
+		}
+	}
+}
+
+func TestUnaryServerIDInterceptorTrailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		sampled bool
+	}{
+		{name: "sampled", sampled: true},
+		{name: "unsampled", sampled: false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			lis, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := grpc.NewServer(grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
+				grpc_ctxtags.UnaryServerInterceptor(),
+				otgrpc.OpenTracingServerInterceptor(newTestTracer(tc.sampled)),
+				UnaryServerIDInterceptor(),
+			)))
+			healthpb.RegisterHealthServer(s, health.NewServer())
+			go s.Serve(lis)
+			defer s.Stop()
+
+			conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			// the health server fails checks of unknown services
+			var trailer metadata.MD
+			_, err = healthpb.NewHealthClient(conn).Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}, grpc.Trailer(&trailer))
+			if grpc.Code(err) != codes.NotFound {
+				t.Fatalf("got code %v, want %v", grpc.Code(err), codes.NotFound)
+			}
+			ids := trailer[TraceIDTrailer]
+			if tc.sampled && (len(ids) != 1 || ids[0] == "") {
+				t.Errorf("got trace ids %v in the trailer, want one", ids)
+			}
+			if !tc.sampled && len(ids) != 0 {
+				t.Errorf("got trace ids %v in the trailer, want none", ids)
+			}
+		})
+	}
+}
+
+func TestStreamServerIDInterceptorTrailer(t *testing.T) {
+	tests := []struct {
+		name    string
+		tracer  func() opentracing.Tracer
+		sampled bool
+	}{
+		{
+			name:    "sampled",
+			tracer:  func() opentracing.Tracer { return newTestTracer(true) },
+			sampled: true,
+		},
+		{
+			name:   "unsampled",
+			tracer: func() opentracing.Tracer { return newTestTracer(false) },
+		},
+		{
+			name:   "noop",
+			tracer: func() opentracing.Tracer { return opentracing.NoopTracer{} },
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			ss := &testServerStream{ctx: context.Background()}
+			info := &grpc.StreamServerInfo{FullMethod: "/com.Pinger/PingStream"}
+			handler := func(srv interface{}, stream grpc.ServerStream) error {
+				return grpc.Errorf(codes.Internal, "broken")
+			}
+			interceptor := grpc_middleware.ChainStreamServer(
+				grpc_ctxtags.StreamServerInterceptor(),
+				StreamServerInterceptor(tc.tracer()),
+				StreamServerIDInterceptor(),
+			)
+
+			if err := interceptor(nil, ss, info, handler); grpc.Code(err) != codes.Internal {
+				t.Fatalf("got code %v, want %v", grpc.Code(err), codes.Internal)
+			}
+			ids := ss.trailer[TraceIDTrailer]
+			if tc.sampled && (len(ids) != 1 || ids[0] == "") {
+				t.Errorf("got trace ids %v in the trailer, want one", ids)
+			}
+			if !tc.sampled && len(ids) != 0 {
+				t.Errorf("got trace ids %v in the trailer, want none", ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+// Package tracing provides opentracing interceptors for streaming rpcs, the
+// streaming counterpart of the unary interceptors in otgrpc, and
+// interceptors exposing the jaeger trace ids of rpcs in logs and trailers.
+package tracing
+
+import (
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	"github.com/opentracing/opentracing-go/log"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+var componentTag = opentracing.Tag{Key: string(ext.Component), Value: "gRPC"}
+
+// StreamServerInterceptor starts a server span for every stream, as a child
+// of the span context sent by the client if there is one. The span is
+// available from the stream context and finished when the handler returns.
+func StreamServerInterceptor(tracer opentracing.Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		md, ok := metadata.FromIncomingContext(ss.Context())
+		if !ok {
+			md = metadata.New(nil)
+		}
+		spanContext, _ := tracer.Extract(opentracing.HTTPHeaders, metadataReaderWriter{md})
+
+		serverSpan := tracer.StartSpan(
+			info.FullMethod,
+			ext.RPCServerOption(spanContext),
+			componentTag,
+		)
+		defer serverSpan.Finish()
+
+		wrapped := grpc_middleware.WrapServerStream(ss)
+		wrapped.WrappedContext = opentracing.ContextWithSpan(ss.Context(), serverSpan)
+
+		err := handler(srv, wrapped)
+		if err != nil {
+			otgrpc.SetSpanTags(serverSpan, err, false)
+			serverSpan.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+		}
+		return err
+	}
+}
+
+// StreamClientInterceptor starts a client span for every stream as a child of
+// the span in the calling context and sends its span context to the server.
+// The span is finished when the stream ends, fails or its context is done.
+func StreamClientInterceptor(tracer opentracing.Tracer) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		var parentCtx opentracing.SpanContext
+		if parent := opentracing.SpanFromContext(ctx); parent != nil {
+			parentCtx = parent.Context()
+		}
+		clientSpan := tracer.StartSpan(
+			method,
+			opentracing.ChildOf(parentCtx),
+			ext.SpanKindRPCClient,
+			componentTag,
+		)
+
+		md, ok := metadata.FromOutgoingContext(ctx)
+		if !ok {
+			md = metadata.New(nil)
+		} else {
+			md = md.Copy()
+		}
+		if err := tracer.Inject(clientSpan.Context(), opentracing.HTTPHeaders, metadataReaderWriter{md}); err != nil {
+			clientSpan.LogFields(log.String("event", "Tracer.Inject() failed"), log.Error(err))
+		}
+		ctx = metadata.NewOutgoingContext(ctx, md)
+		ctx = opentracing.ContextWithSpan(ctx, clientSpan)
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			finishClientSpan(clientSpan, err)
+			return nil, err
+		}
+
+		stream := &tracedClientStream{
+			ClientStream: cs,
+			desc:         desc,
+			span:         clientSpan,
+			done:         make(chan struct{}),
+		}
+		go func() {
+			select {
+			case <-stream.done:
+			case <-cs.Context().Done():
+				stream.finish(cs.Context().Err())
+			}
+		}()
+		return stream, nil
+	}
+}
+
+type tracedClientStream struct {
+	grpc.ClientStream
+	desc *grpc.StreamDesc
+	span opentracing.Span
+
+	once sync.Once
+	done chan struct{}
+}
+
+func (s *tracedClientStream) Header() (metadata.MD, error) {
+	md, err := s.ClientStream.Header()
+	if err != nil {
+		s.finish(err)
+	}
+	return md, err
+}
+
+func (s *tracedClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *tracedClientStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.finish(err)
+	}
+	return err
+}
+
+func (s *tracedClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == io.EOF:
+		s.finish(nil)
+	case err != nil:
+		s.finish(err)
+	case !s.desc.ServerStreams:
+		// a single response ends the stream
+		s.finish(nil)
+	}
+	return err
+}
+
+func (s *tracedClientStream) finish(err error) {
+	s.once.Do(func() {
+		close(s.done)
+		finishClientSpan(s.span, err)
+	})
+}
+
+func finishClientSpan(span opentracing.Span, err error) {
+	if err != nil && err != io.EOF {
+		otgrpc.SetSpanTags(span, err, true)
+		span.LogFields(log.String("event", "error"), log.String("message", err.Error()))
+	}
+	span.Finish()
+}
+
+// metadataReaderWriter satisfies both the opentracing.TextMapReader and
+// opentracing.TextMapWriter interfaces.
+type metadataReaderWriter struct {
+	metadata.MD
+}
+
+func (w metadataReaderWriter) Set(key, val string) {
+	// grpc rejects uppercase metadata keys and http headers are case
+	// insensitive anyway
+	key = strings.ToLower(key)
+	w.MD[key] = append(w.MD[key], val)
+}
+
+func (w metadataReaderWriter) ForeachKey(handler func(key, val string) error) error {
+	for k, vals := range w.MD {
+		for _, v := range vals {
+			if err := handler(k, v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
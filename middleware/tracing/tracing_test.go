@@ -0,0 +1,242 @@
+package tracing
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// testTracer hands out testSpans, propagating span contexts like the noop
+// tracer.
+type testTracer struct {
+	opentracing.NoopTracer
+
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+func (t *testTracer) StartSpan(operationName string, opts ...opentracing.StartSpanOption) opentracing.Span {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	span := &testSpan{Span: t.NoopTracer.StartSpan(operationName), tags: map[string]interface{}{}}
+	t.spans = append(t.spans, span)
+	return span
+}
+
+func (t *testTracer) span(tb testing.TB) *testSpan {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) != 1 {
+		tb.Fatalf("got %v spans, want 1", len(t.spans))
+	}
+	return t.spans[0]
+}
+
+// testSpan records the tags set on a noop span and whether it was finished.
+type testSpan struct {
+	opentracing.Span
+
+	mu       sync.Mutex
+	tags     map[string]interface{}
+	finished int
+}
+
+func (s *testSpan) SetTag(key string, value interface{}) opentracing.Span {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags[key] = value
+	return s
+}
+
+func (s *testSpan) Finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.finished++
+}
+
+func (s *testSpan) state() (int, interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.finished, s.tags["error"]
+}
+
+// testClientStream returns the queued errors from RecvMsg, nil once they
+// are used up.
+type testClientStream struct {
+	grpc.ClientStream
+	ctx  context.Context
+	recv []error
+}
+
+func (s *testClientStream) Context() context.Context { return s.ctx }
+
+func (s *testClientStream) RecvMsg(m interface{}) error {
+	if len(s.recv) == 0 {
+		return nil
+	}
+	err := s.recv[0]
+	s.recv = s.recv[1:]
+	return err
+}
+
+// testServerStream is a server stream with the given context.
+type testServerStream struct {
+	grpc.ServerStream
+	ctx     context.Context
+	trailer metadata.MD
+}
+
+func (s *testServerStream) Context() context.Context { return s.ctx }
+
+func (s *testServerStream) SetTrailer(md metadata.MD) { s.trailer = metadata.Join(s.trailer, md) }
+
+func TestStreamClientInterceptor(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverStreams bool
+		recv          []error
+		finished      int
+		errorTag      interface{}
+	}{
+		{
+			name:          "open",
+			serverStreams: true,
+			recv:          []error{nil},
+			finished:      0,
+		},
+		{
+			name:          "eof",
+			serverStreams: true,
+			recv:          []error{nil, io.EOF},
+			finished:      1,
+		},
+		{
+			name:          "error",
+			serverStreams: true,
+			recv:          []error{nil, grpc.Errorf(codes.Unavailable, "gone")},
+			finished:      1,
+			errorTag:      true,
+		},
+		{
+			name:          "single response",
+			serverStreams: false,
+			recv:          []error{nil},
+			finished:      1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tracer := &testTracer{}
+			streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return &testClientStream{ctx: context.Background(), recv: append([]error(nil), tc.recv...)}, nil
+			}
+
+			desc := &grpc.StreamDesc{ServerStreams: tc.serverStreams}
+			cs, err := StreamClientInterceptor(tracer)(context.Background(), desc, nil, "/com.Pinger/PingStream", streamer)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			for range tc.recv {
+				cs.RecvMsg(nil)
+			}
+			// further messages don't finish the span again
+			cs.RecvMsg(nil)
+
+			finished, errorTag := tracer.span(t).state()
+			if finished != tc.finished {
+				t.Errorf("span finished %v times, want %v", finished, tc.finished)
+			}
+			if errorTag != tc.errorTag {
+				t.Errorf("error tag %v, want %v", errorTag, tc.errorTag)
+			}
+		})
+	}
+}
+
+func TestStreamClientInterceptorStreamerError(t *testing.T) {
+	tracer := &testTracer{}
+	streamer := func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return nil, grpc.Errorf(codes.Unavailable, "gone")
+	}
+
+	_, err := StreamClientInterceptor(tracer)(context.Background(), &grpc.StreamDesc{ServerStreams: true}, nil, "/com.Pinger/PingStream", streamer)
+	if grpc.Code(err) != codes.Unavailable {
+		t.Fatalf("got code %v, want %v", grpc.Code(err), codes.Unavailable)
+	}
+	finished, errorTag := tracer.span(t).state()
+	if finished != 1 || errorTag != true {
+		t.Errorf("span finished %v times with error tag %v, want once with error", finished, errorTag)
+	}
+}
+
+func TestStreamClientInterceptorContextDone(t *testing.T) {
+	tracer := &testTracer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	streamer := func(_ context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return &testClientStream{ctx: ctx}, nil
+	}
+
+	if _, err := StreamClientInterceptor(tracer)(ctx, &grpc.StreamDesc{ServerStreams: true}, nil, "/com.Pinger/PingStream", streamer); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+
+	span := tracer.span(t)
+	for i := 0; i < 100; i++ {
+		if finished, _ := span.state(); finished == 1 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("span not finished after the stream context was canceled")
+}
+
+func TestStreamServerInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		errorTag interface{}
+	}{
+		{
+			name: "ok",
+		},
+		{
+			name:     "error",
+			err:      grpc.Errorf(codes.Internal, "broken"),
+			errorTag: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tracer := &testTracer{}
+			ss := &testServerStream{ctx: context.Background()}
+			info := &grpc.StreamServerInfo{FullMethod: "/com.Pinger/PingStream"}
+			handler := func(srv interface{}, stream grpc.ServerStream) error {
+				if opentracing.SpanFromContext(stream.Context()) == nil {
+					t.Errorf("no span in the stream context")
+				}
+				return tc.err
+			}
+
+			if err := StreamServerInterceptor(tracer)(nil, ss, info, handler); err != tc.err {
+				t.Fatalf("got error %v, want %v", err, tc.err)
+			}
+			finished, errorTag := tracer.span(t).state()
+			if finished != 1 {
+				t.Errorf("span finished %v times, want 1", finished)
+			}
+			if errorTag != tc.errorTag {
+				t.Errorf("error tag %v, want %v", errorTag, tc.errorTag)
+			}
+		})
+	}
+}
@@ -1,38 +1,65 @@
 package main
 
 import (
-	"fmt"
 	"io"
-	"net"
-	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
-
-	"github.com/grpc-ecosystem/go-grpc-middleware"
-	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
-	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
-	"github.com/grpc-ecosystem/go-grpc-prometheus"
-	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
 	pb "github.com/mad01/pingpong/com"
+	"github.com/mad01/pingpong/grpcutil"
+	"github.com/mad01/pingpong/middleware/breaker"
+	"github.com/mad01/pingpong/middleware/metrics"
+	"github.com/mad01/pingpong/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
 	"golang.org/x/net/context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
+// service name as reported by the grpc health service
+const pingerService = "com.Pinger"
+
+// maxResponseSize limits the padding a ping can request, keeping pongs well
+// below the default grpc message size limit.
+const maxResponseSize = 1 << 20
+
+// minStreamInterval is the shortest time between pongs PingStream accepts,
+// so that a stream can't keep the server sending in a busy loop.
+const minStreamInterval = 10 * time.Millisecond
+
 type pingServer struct {
 	cc     *grpc.ClientConn
 	closer io.Closer
+
+	// fallbackMsg is answered while the circuit breaker to RandomMsg is
+	// open, if set
+	fallbackMsg string
+
+	// downstreamShare observes the share of the Ping latency spent waiting on
+	// RandomMsg
+	downstreamShare prometheus.Histogram
 }
 
-func (p *pingServer) MsgConn(addr string) error {
-	cc, closer, err := clientGRPCconn(addr, "pinger")
+// newPingServer creates the pinger with its metrics registered with reg.
+func newPingServer(fallbackMsg string, reg prometheus.Registerer) *pingServer {
+	return &pingServer{
+		fallbackMsg: fallbackMsg,
+		downstreamShare: metrics.NewHistogram(reg, prometheus.HistogramOpts{
+			Namespace: "pingpong",
+			Subsystem: "ping",
+			Name:      "downstream_latency_ratio",
+			Help:      "Histogram of the share of the Ping latency spent waiting on RandomMsg.",
+			Buckets:   []float64{0.1, 0.25, 0.5, 0.75, 0.9, 0.95, 0.99, 1},
+		}),
+	}
+}
+
+func (p *pingServer) MsgConn(addr string, c *config, reg prometheus.Registerer, logger *zap.Logger, interceptors ...grpc.UnaryClientInterceptor) error {
+	cc, closer, err := clientGRPCconn(addr, "pinger", c, reg, logger, interceptors...)
 	if err != nil {
 		return err
 	}
@@ -42,105 +69,234 @@ func (p *pingServer) MsgConn(addr string) error {
 	return nil
 }
 
+// Close closes the connection to RandomMsg and flushes its tracer.
+func (p *pingServer) Close() error {
+	if p.cc != nil {
+		p.cc.Close()
+	}
+	if p.closer != nil {
+		p.closer.Close()
+	}
+	return nil
+}
+
 func (p *pingServer) Ping(ctx context.Context, in *pb.PingRequest) (*pb.PongResponse, error) {
+	received := time.Now().UnixNano()
+	if in.ResponseSize > maxResponseSize {
+		return nil, status.Errorf(codes.InvalidArgument, "response size %v exceeds max of %v", in.ResponseSize, maxResponseSize)
+	}
+
 	client := pb.NewRandomMsgClient(p.cc)
+	downstreamStart := time.Now()
 	msgResp, err := client.GetRandomMsg(ctx, &pb.RandomMsgRequest{}) // use incomming context to take span for tracing
+	downstream := time.Since(downstreamStart)
+	if err == breaker.ErrOpen && p.fallbackMsg != "" {
+		return newPong(p.fallbackMsg, in, received), nil
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Fail to get msg from downstream: %v", err.Error())
+		return nil, downstreamError(err)
 	}
 
-	response := pb.PongResponse{Msg: msgResp.Msg}
-	return &response, nil
+	pong := newPong(msgResp.Msg, in, received)
+	total := time.Duration(pong.ServerSendUnixNano - received)
+	if total > 0 {
+		p.downstreamShare.Observe(float64(downstream) / float64(total))
+	}
+	return pong, nil
 }
 
-func servePingGRPC(conf *config, errChan chan error) {
-	lis, err := net.Listen("tcp", conf.grpcPingerAddr)
-	if err != nil {
-		errChan <- err
-	}
+// downstreamRetryDelay is the delay suggested to callers when randommsg is
+// unavailable or overloaded.
+const downstreamRetryDelay = 100 * time.Millisecond
 
-	//
-	// opentracing
+// downstreamError converts an error from randommsg into the error returned to
+// the caller of the pinger. Codes that describe the call itself, like an
+// expired deadline, are kept while codes that describe the request to
+// randommsg become Internal. The original code is attached as ErrorInfo and
+// retryable errors carry a RetryInfo.
+func downstreamError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		st = status.New(codes.Unknown, err.Error())
+	}
 
-	tracer, closer, err := getTracer("pinger")
-	if err != nil {
-		errChan <- err
+	code := codes.Internal
+	retryable := false
+	switch st.Code() {
+	case codes.DeadlineExceeded, codes.Canceled:
+		code = st.Code()
+	case codes.Unavailable, codes.ResourceExhausted:
+		code = st.Code()
+		retryable = true
 	}
 
-	//
-	//
+	details := []proto.Message{
+		&errdetails.ErrorInfo{
+			Reason: "DOWNSTREAM_ERROR",
+			Domain: pingerService,
+			Metadata: map[string]string{
+				"service": randomMsgService,
+				"code":    st.Code().String(),
+			},
+		},
+	}
+	if retryable {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(downstreamRetryDelay)})
+	}
 
-	//
-	// zap
-	zapOpts := []grpc_zap.Option{
-		grpc_zap.WithDurationField(func(duration time.Duration) zapcore.Field {
-			return zap.Int64("grpc.time_ns", duration.Nanoseconds())
-		}),
+	downstream := status.Newf(code, "failed to get msg from %v: %v", randomMsgService, st.Message())
+	withDetails, detailsErr := downstream.WithDetails(details...)
+	if detailsErr != nil {
+		return downstream.Err()
 	}
-	zapLogger, _ := zap.NewProduction()
-	defer zapLogger.Sync() // flushes buffer, if any
-	//
-	//
-
-	middlewareServer := grpc.NewServer(
-		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			grpc_ctxtags.StreamServerInterceptor(),
-			grpc_zap.StreamServerInterceptor(zapLogger, zapOpts...),
-			grpc_prometheus.StreamServerInterceptor,
-		)),
-		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			otgrpc.OpenTracingServerInterceptor(*tracer),
-			grpc_zap.UnaryServerInterceptor(zapLogger, zapOpts...),
-			grpc_prometheus.UnaryServerInterceptor,
-		)),
-	)
+	return withDetails.Err()
+}
 
-	pinger := pingServer{}
-	pinger.MsgConn(conf.grpcMsgAddr)
+// newPong answers in with msg, echoing its sequence number and send time and
+// adding the requested padding.
+func newPong(msg string, in *pb.PingRequest, received int64) *pb.PongResponse {
+	response := pb.PongResponse{
+		Msg:                   msg,
+		Seq:                   in.Seq,
+		ClientSendUnixNano:    in.ClientSendUnixNano,
+		ServerReceiveUnixNano: received,
+	}
+	if in.ResponseSize > 0 {
+		response.Padding = make([]byte, in.ResponseSize)
+	}
+	response.ServerSendUnixNano = time.Now().UnixNano()
+	return &response
+}
 
-	pb.RegisterPingerServer(middlewareServer, &pinger)
+// PingStream sends in.Count pongs echoing the ping msg, waiting in.IntervalMs
+// between them. A count of 0 keeps sending until the client cancels. The
+// pongs are numbered from 1 in their seq.
+func (p *pingServer) PingStream(in *pb.PingStreamRequest, stream pb.Pinger_PingStreamServer) error {
+	received := time.Now().UnixNano()
+	interval := time.Duration(in.IntervalMs) * time.Millisecond
+	if in.Count != 1 && interval < minStreamInterval {
+		return status.Errorf(codes.InvalidArgument, "interval %v is below the min of %v", interval, minStreamInterval)
+	}
 
-	grpc_prometheus.Register(middlewareServer)
-	grpc_prometheus.EnableHandlingTimeHistogram()
+	for i := uint32(0); in.Count == 0 || i < in.Count; i++ {
+		if i > 0 {
+			select {
+			case <-stream.Context().Done():
+				return grpcutil.ContextError(stream.Context())
+			case <-time.After(interval):
+			}
+		}
 
-	// Register reflection service on gRPC server.
-	reflection.Register(middlewareServer)
+		response := pb.PongResponse{
+			Msg:                   in.Msg,
+			Seq:                   uint64(i) + 1,
+			ServerReceiveUnixNano: received,
+			ServerSendUnixNano:    time.Now().UnixNano(),
+		}
+		if err := stream.Send(&response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	go func() {
-		defer pinger.cc.Close()
-		defer pinger.closer.Close()
-		defer closer.Close()
-		errChan <- middlewareServer.Serve(lis)
-	}()
+// PingPong echoes every ping received on the stream with a pong until the
+// client closes its side of the stream.
+func (p *pingServer) PingPong(stream pb.Pinger_PingPongServer) error {
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		received := time.Now().UnixNano()
+		if in.ResponseSize > maxResponseSize {
+			return status.Errorf(codes.InvalidArgument, "response size %v exceeds max of %v", in.ResponseSize, maxResponseSize)
+		}
 
+		if err := stream.Send(newPong(in.Msg, in, received)); err != nil {
+			return err
+		}
+	}
 }
 
-func servePingHTTP(conf *config, errChan chan error) {
-	http.Handle("/metrics2", promhttp.Handler())
-	go func() {
-		errChan <- http.ListenAndServe(conf.httpPingerAddr, nil)
-	}()
-}
+// servePingAll runs the pinger until stop is closed or one of its servers
+// fails, and then drains it.
+func servePingAll(c *config, stop <-chan struct{}) error {
+	logger, level, err := newLogger(c)
+	if err != nil {
+		return err
+	}
 
-func servePingAll(c *config) {
-	errChan := make(chan error, 10)
+	creds, err := serverCredentials(c, logger)
+	if err != nil {
+		return err
+	}
+	unaryAuth, streamAuth, err := authInterceptors(c)
+	if err != nil {
+		return err
+	}
+	registry := prometheus.NewRegistry()
+	unaryLimit, streamLimit := limitInterceptors(c, registry)
 
-	go servePingHTTP(c, errChan)
-	go servePingGRPC(c, errChan)
+	tracer, closer, sampling, err := getSwitchableTracer("pinger", c)
+	if err != nil {
+		return err
+	}
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	for {
-		select {
-		case err := <-errChan:
-			if err != nil {
-				fmt.Printf("%s \n", err)
-				os.Exit(1)
-			}
-		case <-signalChan:
-			fmt.Println("Shutdown signal received, exiting...")
-			os.Exit(0)
-		}
+	pinger := newPingServer(c.breakerFallbackMsg, registry)
+	var interceptors []grpc.UnaryClientInterceptor
+	var b *breaker.Breaker
+	if c.breaker {
+		b = breaker.New(
+			randomMsgService,
+			breaker.WithWindow(c.breakerWindow),
+			breaker.WithErrorRate(c.breakerErrorRate, c.breakerMinRequests),
+			breaker.WithLatency(c.breakerLatency),
+			breaker.WithOpenTimeout(c.breakerOpenTimeout),
+			breaker.WithHalfOpenRequests(c.breakerHalfOpenRequests),
+			breaker.WithLogger(logger),
+			breaker.WithRegisterer(registry),
+		)
+		interceptors = append(interceptors, b.UnaryClientInterceptor())
+	}
+	if err := pinger.MsgConn(c.grpcMsgAddr, c, registry, logger, interceptors...); err != nil {
+		closer.Close()
+		return err
 	}
+
+	buckets, _ := parseBuckets(c.metricsPingBuckets)   // validated with the config
+	quantiles, _ := parseQuantiles(c.metricsQuantiles) // validated with the config
+	srv, err := server.New(
+		"pinger",
+		server.WithGRPCAddr(c.grpcPingerAddr),
+		server.WithHTTPAddr(c.httpPingerAddr),
+		server.WithRegistry(registry),
+		server.WithHandlingTimeBuckets(buckets...),
+		server.WithQuantiles(quantiles...),
+		server.WithShutdownTimeout(c.shutdownTimeout),
+		server.WithDefaultTimeout(c.defaultTimeout),
+		server.WithCredentials(creds),
+		server.WithTracer(*tracer, closer),
+		server.WithLogger(logger),
+		server.WithUnaryInterceptors(append(unaryAuth, unaryLimit...)...),
+		server.WithStreamInterceptors(append(streamAuth, streamLimit...)...),
+	)
+	if err != nil {
+		pinger.Close()
+		closer.Close()
+		return err
+	}
+	srv.AddCloser(pinger)
+
+	pb.RegisterPingerServer(srv.GRPCServer(), pinger)
+	handleAdmin(srv, c, level, sampling, nil)
+	handleDownstream(srv, c, pinger.cc, b)
+
+	// pinger is only healthy while its downstream randommsg connection is ready
+	srv.WatchConn(pingerService, pinger.cc)
+
+	return srv.Serve(stop)
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"testing"
+
+	pb "github.com/mad01/pingpong/com"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// testPingStream collects the pongs sent on a PingStream.
+type testPingStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	pongs []*pb.PongResponse
+}
+
+func (s *testPingStream) Context() context.Context { return s.ctx }
+
+func (s *testPingStream) Send(pong *pb.PongResponse) error {
+	s.pongs = append(s.pongs, pong)
+	return nil
+}
+
+func TestPingStream(t *testing.T) {
+	tests := []struct {
+		name       string
+		count      uint32
+		intervalMs uint32
+		code       codes.Code
+		pongs      int
+	}{
+		{
+			name:       "count",
+			count:      3,
+			intervalMs: 10,
+			code:       codes.OK,
+			pongs:      3,
+		},
+		{
+			name:  "single pong without interval",
+			count: 1,
+			code:  codes.OK,
+			pongs: 1,
+		},
+		{
+			name:       "interval below min",
+			count:      3,
+			intervalMs: 1,
+			code:       codes.InvalidArgument,
+		},
+		{
+			name:  "unbounded without interval",
+			count: 0,
+			code:  codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &pingServer{}
+			stream := &testPingStream{ctx: context.Background()}
+			in := &pb.PingStreamRequest{Msg: "ping", Count: tc.count, IntervalMs: tc.intervalMs}
+
+			err := p.PingStream(in, stream)
+			if grpc.Code(err) != tc.code {
+				t.Fatalf("got code %v, want %v", grpc.Code(err), tc.code)
+			}
+			if len(stream.pongs) != tc.pongs {
+				t.Fatalf("got %v pongs, want %v", len(stream.pongs), tc.pongs)
+			}
+			for i, pong := range stream.pongs {
+				if pong.Seq != uint64(i+1) {
+					t.Errorf("pong %v has seq %v, want %v", i, pong.Seq, i+1)
+				}
+				if pong.Msg != "ping" {
+					t.Errorf("pong %v has msg %q, want %q", i, pong.Msg, "ping")
+				}
+			}
+		})
+	}
+}
+
+func TestPingStreamCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	stream := &testPingStream{ctx: ctx}
+	in := &pb.PingStreamRequest{Msg: "ping", IntervalMs: 10}
+
+	err := (&pingServer{}).PingStream(in, stream)
+	if grpc.Code(err) != codes.Canceled {
+		t.Fatalf("got code %v, want %v", grpc.Code(err), codes.Canceled)
+	}
+	if len(stream.pongs) != 1 || stream.pongs[0].Seq != 1 {
+		t.Errorf("got pongs %v, want the first one", stream.pongs)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/mad01/pingpong/server"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+	"go.uber.org/zap"
+)
+
+// pushMetrics pushes the metrics gathered from g to the pushgateway at
+// push.url, labeled with the client name as service and grouped by push.job,
+// the host and the client name. Metrics pushed earlier to the same group are
+// replaced. Nothing is pushed without a push.url. A failed push is logged
+// with logger, it doesn't fail the client.
+func pushMetrics(c *config, name string, g prometheus.Gatherer, logger *zap.Logger) {
+	if c.pushURL == "" {
+		return
+	}
+	if err := push(c, name, server.ServiceGatherer(g, name)); err != nil {
+		logger.Warn("failed to push metrics", zap.String("url", c.pushURL), zap.Error(err))
+	}
+}
+
+func push(c *config, name string, g prometheus.Gatherer) error {
+	families, err := g.Gather()
+	if err != nil {
+		return fmt.Errorf("failed to gather metrics: %v", err.Error())
+	}
+
+	var body bytes.Buffer
+	for _, family := range families {
+		if _, err := expfmt.MetricFamilyToText(&body, family); err != nil {
+			return fmt.Errorf("failed to encode metrics: %v", err.Error())
+		}
+	}
+
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	target := fmt.Sprintf(
+		"%v/metrics/job/%v/instance/%v/client/%v",
+		strings.TrimSuffix(c.pushURL, "/"),
+		url.PathEscape(c.pushJob),
+		url.PathEscape(instance),
+		url.PathEscape(name),
+	)
+	request, err := http.NewRequest(http.MethodPut, target, &body)
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", string(expfmt.FmtText))
+
+	client := http.Client{Timeout: c.pushTimeout}
+	resp, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%v responded %v", target, resp.Status)
+	}
+	return nil
+}
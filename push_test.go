@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// pushRequest is a request received by the pushgateway stand-in.
+type pushRequest struct {
+	method      string
+	path        string
+	contentType string
+	body        string
+}
+
+func newTestLogger() (*zap.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	core := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&buf), zap.DebugLevel)
+	return zap.New(core), &buf
+}
+
+func TestPushMetrics(t *testing.T) {
+	instance, err := os.Hostname()
+	if err != nil {
+		instance = "unknown"
+	}
+	instance = url.PathEscape(instance)
+
+	tests := []struct {
+		name   string
+		job    string
+		suffix string
+		status int
+		path   string
+		failed bool
+	}{
+		{
+			name:   "pushed",
+			job:    "pingpong",
+			status: http.StatusAccepted,
+			path:   "/metrics/job/pingpong/instance/" + instance + "/client/cli",
+		},
+		{
+			name:   "url with trailing slash",
+			job:    "pingpong",
+			suffix: "/",
+			status: http.StatusOK,
+			path:   "/metrics/job/pingpong/instance/" + instance + "/client/cli",
+		},
+		{
+			name:   "escaped job",
+			job:    "load/test",
+			status: http.StatusAccepted,
+			path:   "/metrics/job/load%2Ftest/instance/" + instance + "/client/cli",
+		},
+		{
+			name:   "rejected",
+			job:    "pingpong",
+			status: http.StatusInternalServerError,
+			path:   "/metrics/job/pingpong/instance/" + instance + "/client/cli",
+			failed: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []pushRequest
+			gateway := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				requests = append(requests, pushRequest{
+					method:      r.Method,
+					path:        r.URL.EscapedPath(),
+					contentType: r.Header.Get("Content-Type"),
+					body:        string(body),
+				})
+				w.WriteHeader(tt.status)
+			}))
+			defer gateway.Close()
+
+			reg := prometheus.NewRegistry()
+			pings := prometheus.NewCounter(prometheus.CounterOpts{Name: "pings_total", Help: "Total number of pings."})
+			reg.MustRegister(pings)
+			pings.Inc()
+
+			logger, logs := newTestLogger()
+			c := &config{pushURL: gateway.URL + tt.suffix, pushJob: tt.job, pushTimeout: time.Second}
+			pushMetrics(c, "cli", reg, logger)
+
+			if len(requests) != 1 {
+				t.Fatalf("requests = %v, want 1", len(requests))
+			}
+			r := requests[0]
+			if r.method != http.MethodPut || r.path != tt.path {
+				t.Errorf("request = %v %v, want PUT %v", r.method, r.path, tt.path)
+			}
+			if !strings.HasPrefix(r.contentType, "text/plain") {
+				t.Errorf("content type = %q, want the text format", r.contentType)
+			}
+			if !strings.Contains(r.body, `pings_total{service="cli"} 1`) {
+				t.Errorf("body = %q, want pings_total with the service label", r.body)
+			}
+
+			logged := strings.Contains(logs.String(), "failed to push metrics")
+			if logged != tt.failed {
+				t.Errorf("failure logged = %v, want %v: %s", logged, tt.failed, logs.String())
+			}
+		})
+	}
+}
+
+func TestPushMetricsUnreachable(t *testing.T) {
+	gateway := httptest.NewServer(http.NotFoundHandler())
+	addr := gateway.URL
+	gateway.Close()
+
+	logger, logs := newTestLogger()
+	c := &config{pushURL: addr, pushJob: "pingpong", pushTimeout: time.Second}
+	pushMetrics(c, "bench", prometheus.NewRegistry(), logger)
+
+	if !strings.Contains(logs.String(), "failed to push metrics") {
+		t.Errorf("failure not logged: %s", logs.String())
+	}
+}
+
+func TestPushMetricsDisabled(t *testing.T) {
+	logger, logs := newTestLogger()
+	pushMetrics(&config{}, "cli", prometheus.NewRegistry(), logger)
+	if logs.Len() != 0 {
+		t.Errorf("logged %s, want nothing without a push.url", logs.String())
+	}
+}
@@ -2,122 +2,112 @@ package main
 
 import (
 	"fmt"
-	"log"
-	"math/rand"
-	"net"
 	"net/http"
-	"os"
-	"os/signal"
-	"syscall"
 	"time"
 
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
-
-	"github.com/grpc-ecosystem/go-grpc-middleware"
-	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
-	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
-	"github.com/grpc-ecosystem/go-grpc-prometheus"
-	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-
 	pb "github.com/mad01/pingpong/com"
+	"github.com/mad01/pingpong/middleware/fault"
+	"github.com/mad01/pingpong/server"
+	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/reflection"
 )
 
+// service name as reported by the grpc health service
+const randomMsgService = "com.RandomMsg"
+
+// defaultFaults simulates the latency of a real dependency when no fault
+// config is given.
+var defaultFaults = fault.Config{
+	Latency: fault.Latency{
+		Distribution: fault.Uniform,
+		Max:          fault.Duration(200 * time.Millisecond),
+	},
+}
+
 type randomMsgServer struct{}
 
+// GetRandomMsg answers right away, its latency and errors are injected by
+// the fault interceptor in front of it.
 func (s *randomMsgServer) GetRandomMsg(ctx context.Context, in *pb.RandomMsgRequest) (*pb.RandomMsgResponse, error) {
-	time.Sleep(time.Duration(rand.Intn(200)) * time.Millisecond)
 	response := pb.RandomMsgResponse{Msg: "funny random message"}
 	return &response, nil
 }
 
-func serveRandomMsgGRPC(addr string, errChan chan error) {
-	lis, err := net.Listen("tcp", addr)
+// faultInjector returns the injector of randommsg with the configured faults,
+// with its metrics registered with reg.
+func faultInjector(c *config, reg prometheus.Registerer) (*fault.Injector, error) {
+	config := defaultFaults
+	if c.faultsConfig != "" {
+		var err error
+		if config, err = fault.LoadConfig(c.faultsConfig); err != nil {
+			return nil, err
+		}
+	}
+	injector, err := fault.NewInjector(config, c.faults, reg)
 	if err != nil {
-		log.Fatalf("failed to listen: %v", err)
+		return nil, fmt.Errorf("invalid fault config: %v", err.Error())
 	}
+	return injector, nil
+}
 
-	//
-	// opentracing
-
-	tracer, closer, err := getTracer("randommsg")
+// serveRandomMsgAll runs randommsg until stop is closed or one of its servers
+// fails, and then drains it.
+func serveRandomMsgAll(c *config, stop <-chan struct{}) error {
+	logger, level, err := newLogger(c)
 	if err != nil {
-		errChan <- err
+		return err
 	}
 
-	//
-	//
-
-	//
-	// zap
-	zapOpts := []grpc_zap.Option{
-		grpc_zap.WithDurationField(func(duration time.Duration) zapcore.Field {
-			return zap.Int64("grpc.time_ns", duration.Nanoseconds())
-		}),
+	creds, err := serverCredentials(c, logger)
+	if err != nil {
+		return err
+	}
+	unaryAuth, streamAuth, err := authInterceptors(c)
+	if err != nil {
+		return err
+	}
+	registry := prometheus.NewRegistry()
+	unaryLimit, streamLimit := limitInterceptors(c, registry)
+	faults, err := faultInjector(c, registry)
+	if err != nil {
+		return err
+	}
+	tracer, closer, sampling, err := getSwitchableTracer("randommsg", c)
+	if err != nil {
+		return err
 	}
-	zapLogger, _ := zap.NewProduction()
-	defer zapLogger.Sync() // flushes buffer, if any
-	//
-	//
 
-	middlewareServer := grpc.NewServer(
-		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(
-			grpc_ctxtags.StreamServerInterceptor(),
-			grpc_zap.StreamServerInterceptor(zapLogger, zapOpts...),
-			grpc_prometheus.StreamServerInterceptor,
-		)),
-		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(
-			grpc_ctxtags.UnaryServerInterceptor(),
-			otgrpc.OpenTracingServerInterceptor(*tracer),
-			grpc_zap.UnaryServerInterceptor(zapLogger, zapOpts...),
-			grpc_prometheus.UnaryServerInterceptor,
-		)),
+	buckets, _ := parseBuckets(c.metricsMsgBuckets)    // validated with the config
+	quantiles, _ := parseQuantiles(c.metricsQuantiles) // validated with the config
+	srv, err := server.New(
+		"randommsg",
+		server.WithGRPCAddr(c.grpcMsgAddr),
+		server.WithHTTPAddr(c.httpMsgAddr),
+		server.WithRegistry(registry),
+		server.WithHandlingTimeBuckets(buckets...),
+		server.WithQuantiles(quantiles...),
+		server.WithShutdownTimeout(c.shutdownTimeout),
+		server.WithDefaultTimeout(c.defaultTimeout),
+		server.WithCredentials(creds),
+		server.WithTracer(*tracer, closer),
+		server.WithLogger(logger),
+		server.WithUnaryInterceptors(append(unaryAuth, unaryLimit...)...),
+		server.WithUnaryInterceptors(fault.UnaryServerInterceptor(faults)),
+		server.WithStreamInterceptors(append(streamAuth, streamLimit...)...),
+		server.WithListenerWrapper(faults.WrapListener),
 	)
+	if err != nil {
+		closer.Close()
+		return err
+	}
 
-	pb.RegisterRandomMsgServer(middlewareServer, &randomMsgServer{})
-
-	grpc_prometheus.Register(middlewareServer)
-	grpc_prometheus.EnableHandlingTimeHistogram()
-
-	// Register reflection service on gRPC server.
-	reflection.Register(middlewareServer)
-
-	go func() {
-		defer closer.Close()
-		errChan <- middlewareServer.Serve(lis)
-	}()
-
-}
-
-func serveRandomMsgHTTP(addr string, errChan chan error) {
-	http.Handle("/metrics", promhttp.Handler())
-	go func() {
-		errChan <- http.ListenAndServe(addr, nil)
-	}()
-}
-
-func serveRandomMsgAll(c *config) {
-	errChan := make(chan error, 10)
-
-	go serveRandomMsgHTTP(c.httpMsgAddr, errChan)
-	go serveRandomMsgGRPC(c.grpcMsgAddr, errChan)
-
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	for {
-		select {
-		case err := <-errChan:
-			if err != nil {
-				fmt.Printf("%s \n", err)
-				os.Exit(1)
-			}
-		case <-signalChan:
-			fmt.Println("Shutdown signal received, exiting...")
-			os.Exit(0)
-		}
+	pb.RegisterRandomMsgServer(srv.GRPCServer(), &randomMsgServer{})
+	var faultsHandler http.Handler
+	if c.faults {
+		faultsHandler = faults
 	}
+	handleAdmin(srv, c, level, sampling, faultsHandler)
+	srv.SetServingStatus(randomMsgService, true)
+
+	return srv.Serve(stop)
 }
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthServer answers checks of the whole server, the empty service name
+// sent by default by kubernetes and grpc_health_probe, with the aggregate
+// status of the server. The vendored health server always answers them with
+// SERVING, even while draining.
+type healthServer struct {
+	*health.Server
+	server *Server
+}
+
+// Check reports the status of in.Service. The server as a whole is
+// NOT_SERVING while draining or while any of its services is NOT_SERVING.
+func (h *healthServer) Check(ctx context.Context, in *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if in.Service != "" {
+		return h.Server.Check(ctx, in)
+	}
+
+	notServing := &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}
+	h.server.mu.Lock()
+	draining := h.server.draining
+	h.server.mu.Unlock()
+	if draining {
+		return notServing, nil
+	}
+	for service := range h.server.grpcServer.GetServiceInfo() {
+		resp, err := h.Server.Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+		if err != nil {
+			// services without a status, like reflection, don't count
+			continue
+		}
+		if resp.Status != healthpb.HealthCheckResponse_SERVING {
+			return notServing, nil
+		}
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+// SetServingStatus sets the status reported by the health service for
+// service. Updates are ignored once the server has started draining.
+func (s *Server) SetServingStatus(service string, serving bool) {
+	status := healthpb.HealthCheckResponse_NOT_SERVING
+	if serving {
+		status = healthpb.HealthCheckResponse_SERVING
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.draining {
+		return
+	}
+	s.health.SetServingStatus(service, status)
+}
+
+// WatchConn reports service as SERVING while cc is READY and as NOT_SERVING
+// otherwise, until the server is stopped.
+func (s *Server) WatchConn(service string, cc *grpc.ClientConn) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.done
+		cancel()
+	}()
+
+	s.watchers.Add(1)
+	go func() {
+		defer s.watchers.Done()
+		state := cc.GetState()
+		for {
+			s.SetServingStatus(service, state == connectivity.Ready)
+			if !cc.WaitForStateChange(ctx, state) {
+				return
+			}
+			state = cc.GetState()
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthCheckWholeServer(t *testing.T) {
+	s, err := New("test", WithGRPCAddr("127.0.0.1:0"), WithLogger(zap.NewNop()))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.lis.Close()
+	h := &healthServer{Server: s.health, server: s}
+	// a service registered on the grpc server, so that its status counts
+	const service = "grpc.health.v1.Health"
+
+	tests := []struct {
+		name     string
+		serving  bool
+		draining bool
+		want     healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{name: "serving", serving: true, want: healthpb.HealthCheckResponse_SERVING},
+		{name: "service not serving", serving: false, want: healthpb.HealthCheckResponse_NOT_SERVING},
+		{name: "draining", serving: true, draining: true, want: healthpb.HealthCheckResponse_NOT_SERVING},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s.SetServingStatus(service, tt.serving)
+			s.mu.Lock()
+			s.draining = tt.draining
+			s.mu.Unlock()
+
+			resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{})
+			if err != nil {
+				t.Fatalf("Check: %v", err)
+			}
+			if resp.Status != tt.want {
+				t.Errorf("status = %v, want %v", resp.Status, tt.want)
+			}
+		})
+	}
+}
+
+func TestHealthCheckService(t *testing.T) {
+	s, err := New("test", WithGRPCAddr("127.0.0.1:0"), WithLogger(zap.NewNop()))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer s.lis.Close()
+	h := &healthServer{Server: s.health, server: s}
+
+	s.SetServingStatus("com.Pinger", false)
+	resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "com.Pinger"})
+	if err != nil {
+		t.Fatalf("Check: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Errorf("status = %v, want NOT_SERVING", resp.Status)
+	}
+	if _, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{Service: "unknown"}); err == nil {
+		t.Errorf("Check of an unknown service succeeded")
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// serviceLabel is the label every metric of a server is exported with, so
+// that the services of a process can be told apart after scraping.
+const serviceLabel = "service"
+
+// ServiceGatherer returns a gatherer adding the service label servers export
+// their metrics with to every metric of g, for metrics that are pushed
+// rather than scraped from a server.
+func ServiceGatherer(g prometheus.Gatherer, service string) prometheus.Gatherer {
+	return labeledGatherer{Gatherer: g, name: serviceLabel, value: service}
+}
+
+// labeledGatherer adds a constant label to every metric of a gatherer.
+type labeledGatherer struct {
+	prometheus.Gatherer
+	name  string
+	value string
+}
+
+func (g labeledGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.Gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			metric.Label = append(metric.Label, &dto.LabelPair{
+				Name:  proto.String(g.name),
+				Value: proto.String(g.value),
+			})
+			sort.Sort(labelPairs(metric.Label))
+		}
+	}
+	return families, err
+}
+
+type labelPairs []*dto.LabelPair
+
+func (p labelPairs) Len() int           { return len(p) }
+func (p labelPairs) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p labelPairs) Less(i, j int) bool { return p[i].GetName() < p[j].GetName() }
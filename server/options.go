@@ -0,0 +1,162 @@
+package server
+
+import (
+	"io"
+	"net"
+	"time"
+
+	opentracing "github.com/opentracing/opentracing-go"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+type options struct {
+	grpcAddr        string
+	httpAddr        string
+	metricsPath     string
+	shutdownTimeout time.Duration
+	defaultTimeout  time.Duration
+	creds           credentials.TransportCredentials
+	wrapListener    func(net.Listener) net.Listener
+	registry        *prometheus.Registry
+	buckets         []float64
+	quantiles       []float64
+
+	tracer       opentracing.Tracer
+	tracerCloser io.Closer
+	logger       *zap.Logger
+
+	unaryInterceptors  []grpc.UnaryServerInterceptor
+	streamInterceptors []grpc.StreamServerInterceptor
+}
+
+func defaultOptions() options {
+	return options{
+		grpcAddr:        "0.0.0.0:0",
+		httpAddr:        "",
+		metricsPath:     "/metrics",
+		shutdownTimeout: 10 * time.Second,
+		buckets:         prometheus.DefBuckets,
+		tracer:          opentracing.NoopTracer{},
+	}
+}
+
+// Option configures a Server.
+type Option func(*options)
+
+// WithGRPCAddr sets the address the grpc server listens on.
+func WithGRPCAddr(addr string) Option {
+	return func(o *options) {
+		o.grpcAddr = addr
+	}
+}
+
+// WithHTTPAddr sets the address the metrics endpoint listens on. The http
+// server is not started when no address is set.
+func WithHTTPAddr(addr string) Option {
+	return func(o *options) {
+		o.httpAddr = addr
+	}
+}
+
+// WithMetricsPath sets the path prometheus metrics are served on, /metrics
+// by default.
+func WithMetricsPath(path string) Option {
+	return func(o *options) {
+		o.metricsPath = path
+	}
+}
+
+// WithRegistry sets the registry whose metrics are served, so that
+// middleware passed to the server can register its metrics with it. Every
+// server gets a registry of its own by default. The grpc, process and go
+// runtime metrics are registered with it by the server.
+func WithRegistry(registry *prometheus.Registry) Option {
+	return func(o *options) {
+		o.registry = registry
+	}
+}
+
+// WithHandlingTimeBuckets sets the buckets, in seconds, of the grpc handling
+// time histogram of the server, prometheus.DefBuckets by default.
+func WithHandlingTimeBuckets(buckets ...float64) Option {
+	return func(o *options) {
+		o.buckets = buckets
+	}
+}
+
+// WithQuantiles adds a summary of the grpc handling time with the given
+// quantiles, e.g. 0.5, 0.9 and 0.99. No summary is exported by default as
+// summaries can't be aggregated over instances.
+func WithQuantiles(quantiles ...float64) Option {
+	return func(o *options) {
+		o.quantiles = quantiles
+	}
+}
+
+// WithShutdownTimeout sets how long in-flight requests are given to finish
+// once the server is stopped.
+func WithShutdownTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.shutdownTimeout = timeout
+	}
+}
+
+// WithDefaultTimeout sets the timeout of unary rpcs whose caller sent no
+// deadline. Rpcs without a deadline are not bounded by default.
+func WithDefaultTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.defaultTimeout = timeout
+	}
+}
+
+// WithCredentials sets the transport credentials of the grpc server, e.g.
+// to serve tls. The server is insecure by default.
+func WithCredentials(creds credentials.TransportCredentials) Option {
+	return func(o *options) {
+		o.creds = creds
+	}
+}
+
+// WithListenerWrapper wraps the listener of the grpc server, e.g. to track
+// the connections it accepts.
+func WithListenerWrapper(wrap func(net.Listener) net.Listener) Option {
+	return func(o *options) {
+		o.wrapListener = wrap
+	}
+}
+
+// WithTracer sets the tracer used for incoming requests. The closer is
+// closed once the server is stopped to flush buffered spans.
+func WithTracer(tracer opentracing.Tracer, closer io.Closer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+		o.tracerCloser = closer
+	}
+}
+
+// WithLogger sets the logger used for request logging. A zap production
+// logger is used by default.
+func WithLogger(logger *zap.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithUnaryInterceptors appends interceptors to the unary chain, after the
+// built-in logging, tracing and metrics interceptors.
+func WithUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) {
+		o.unaryInterceptors = append(o.unaryInterceptors, interceptors...)
+	}
+}
+
+// WithStreamInterceptors appends interceptors to the stream chain, after the
+// built-in logging, tracing and metrics interceptors.
+func WithStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) {
+		o.streamInterceptors = append(o.streamInterceptors, interceptors...)
+	}
+}
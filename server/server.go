@@ -0,0 +1,265 @@
+// Package server bootstraps the grpc servers of the pingpong services with a
+// shared middleware chain, health service, metrics endpoint and lifecycle.
+package server
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/grpc-ecosystem/go-grpc-middleware"
+	"github.com/grpc-ecosystem/go-grpc-middleware/logging/zap"
+	"github.com/grpc-ecosystem/go-grpc-middleware/tags"
+	"github.com/grpc-ecosystem/go-grpc-prometheus"
+	"github.com/grpc-ecosystem/grpc-opentracing/go/otgrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/mad01/pingpong/middleware/deadline"
+	"github.com/mad01/pingpong/middleware/metrics"
+	"github.com/mad01/pingpong/middleware/recovery"
+	"github.com/mad01/pingpong/middleware/tracing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// Server is a grpc server together with its metrics endpoint and the
+// resources it owns.
+type Server struct {
+	name string
+	opts options
+
+	lis        net.Listener
+	grpcServer *grpc.Server
+	httpServer *http.Server
+	mux        *http.ServeMux
+	health     *health.Server
+	logger     *zap.Logger
+	metrics    *grpc_prometheus.ServerMetrics
+
+	mu       sync.Mutex
+	draining bool
+	closers  []io.Closer
+	watchers sync.WaitGroup
+	done     chan struct{}
+
+	drainOnce sync.Once
+	drainNow  chan struct{}
+}
+
+// New creates a server listening on the configured grpc address. Services
+// are registered on GRPCServer before calling Serve.
+func New(name string, opts ...Option) (*Server, error) {
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	lis, err := net.Listen("tcp", o.grpcAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen: %v", err.Error())
+	}
+	if o.wrapListener != nil {
+		lis = o.wrapListener(lis)
+	}
+
+	logger := o.logger
+	if logger == nil {
+		logger, err = zap.NewProduction()
+		if err != nil {
+			lis.Close()
+			return nil, fmt.Errorf("failed to create logger: %v", err.Error())
+		}
+	}
+
+	registry := o.registry
+	if registry == nil {
+		registry = prometheus.NewRegistry()
+	}
+	registry.MustRegister(prometheus.NewProcessCollector(os.Getpid(), ""), prometheus.NewGoCollector())
+	serverMetrics := grpc_prometheus.NewServerMetrics()
+	serverMetrics.EnableHandlingTimeHistogram(grpc_prometheus.WithHistogramBuckets(o.buckets))
+	registry.MustRegister(serverMetrics)
+	grpcMetrics := metrics.NewServerGRPCMetrics(registry, o.quantiles...)
+
+	zapOpts := []grpc_zap.Option{
+		grpc_zap.WithDurationField(func(duration time.Duration) zapcore.Field {
+			return zap.Int64("grpc.time_ns", duration.Nanoseconds())
+		}),
+	}
+
+	// panics are recovered around the whole chain and again right around the
+	// handler, so that they are logged, traced and counted as Internal errors
+	unary := []grpc.UnaryServerInterceptor{
+		recovery.UnaryServerInterceptor(logger, registry),
+		grpc_ctxtags.UnaryServerInterceptor(),
+		deadline.UnaryServerInterceptor(o.defaultTimeout, registry),
+		otgrpc.OpenTracingServerInterceptor(o.tracer),
+		tracing.UnaryServerIDInterceptor(),
+		grpc_zap.UnaryServerInterceptor(logger, zapOpts...),
+		serverMetrics.UnaryServerInterceptor(),
+		grpcMetrics.UnaryServerInterceptor(),
+	}
+	unary = append(unary, o.unaryInterceptors...)
+	unary = append(unary, recovery.UnaryServerInterceptor(logger, registry))
+
+	stream := []grpc.StreamServerInterceptor{
+		recovery.StreamServerInterceptor(logger, registry),
+		grpc_ctxtags.StreamServerInterceptor(),
+		deadline.StreamServerInterceptor(registry),
+		tracing.StreamServerInterceptor(o.tracer),
+		tracing.StreamServerIDInterceptor(),
+		grpc_zap.StreamServerInterceptor(logger, zapOpts...),
+		serverMetrics.StreamServerInterceptor(),
+		grpcMetrics.StreamServerInterceptor(),
+	}
+	stream = append(stream, o.streamInterceptors...)
+	stream = append(stream, recovery.StreamServerInterceptor(logger, registry))
+
+	serverOpts := []grpc.ServerOption{
+		grpc.StreamInterceptor(grpc_middleware.ChainStreamServer(stream...)),
+		grpc.UnaryInterceptor(grpc_middleware.ChainUnaryServer(unary...)),
+	}
+	if o.creds != nil {
+		serverOpts = append(serverOpts, grpc.Creds(o.creds))
+	}
+
+	s := &Server{
+		name:       name,
+		opts:       o,
+		lis:        lis,
+		health:     health.NewServer(),
+		logger:     logger,
+		metrics:    serverMetrics,
+		done:       make(chan struct{}),
+		drainNow:   make(chan struct{}),
+		grpcServer: grpc.NewServer(serverOpts...),
+	}
+
+	healthpb.RegisterHealthServer(s.grpcServer, &healthServer{Server: s.health, server: s})
+
+	// Register reflection service on gRPC server.
+	reflection.Register(s.grpcServer)
+
+	if o.httpAddr != "" {
+		// every server has its own mux, so that services running in the
+		// same process do not serve each other's endpoints
+		s.mux = http.NewServeMux()
+		gatherer := labeledGatherer{Gatherer: registry, name: serviceLabel, value: name}
+		s.mux.Handle(o.metricsPath, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+		s.httpServer = &http.Server{Addr: o.httpAddr, Handler: s.mux}
+	}
+
+	return s, nil
+}
+
+// GRPCServer returns the underlying grpc server to register services on.
+func (s *Server) GRPCServer() *grpc.Server {
+	return s.grpcServer
+}
+
+// Logger returns the logger used for request logging.
+func (s *Server) Logger() *zap.Logger {
+	return s.logger
+}
+
+// Handle serves handler on pattern next to the metrics endpoint. Nothing is
+// served when the server has no http address.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	if s.mux != nil {
+		s.mux.Handle(pattern, handler)
+	}
+}
+
+// Drain makes Serve drain the server and return, as if stop was closed.
+func (s *Server) Drain() {
+	s.drainOnce.Do(func() {
+		close(s.drainNow)
+	})
+}
+
+// AddCloser registers c to be closed once the server is stopped. Closers are
+// closed in reverse order of registration.
+func (s *Server) AddCloser(c io.Closer) {
+	s.mu.Lock()
+	s.closers = append(s.closers, c)
+	s.mu.Unlock()
+}
+
+// Serve starts the grpc and metrics servers and blocks until stop is closed,
+// Drain is called or one of them fails. The server is then drained and every resource it
+// owns is released.
+func (s *Server) Serve(stop <-chan struct{}) error {
+	s.metrics.InitializeMetrics(s.grpcServer)
+
+	errChan := make(chan error, 2)
+	go func() {
+		errChan <- s.grpcServer.Serve(s.lis)
+	}()
+	if s.httpServer != nil {
+		go func() {
+			if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+				errChan <- err
+			}
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-errChan:
+	case <-stop:
+		s.logger.Info("draining", zap.String("server", s.name))
+	case <-s.drainNow:
+		s.logger.Info("draining on request", zap.String("server", s.name))
+	}
+
+	if drainErr := s.drain(); drainErr != nil && err == nil {
+		err = drainErr
+	}
+	return err
+}
+
+// drain marks all services as NOT_SERVING, waits for in-flight requests to
+// finish and releases the resources owned by the server.
+func (s *Server) drain() error {
+	s.mu.Lock()
+	s.draining = true
+	close(s.done)
+	s.mu.Unlock()
+	s.watchers.Wait()
+
+	for service := range s.grpcServer.GetServiceInfo() {
+		s.health.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+
+	stopGRPC(s.grpcServer, s.opts.shutdownTimeout)
+
+	var err error
+	if s.httpServer != nil {
+		err = stopHTTP(s.httpServer, s.opts.shutdownTimeout)
+	}
+
+	s.mu.Lock()
+	closers := s.closers
+	s.closers = nil
+	s.mu.Unlock()
+	for i := len(closers) - 1; i >= 0; i-- {
+		closers[i].Close()
+	}
+
+	if s.opts.tracerCloser != nil {
+		s.opts.tracerCloser.Close()
+	}
+	s.logger.Sync() // flushes buffer, if any
+	return err
+}
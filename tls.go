@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/mad01/pingpong/certs"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// serverCredentials returns the transport credentials of the grpc servers,
+// or nil when tls is disabled. Failed certificate reloads are logged with
+// logger.
+func serverCredentials(c *config, logger *zap.Logger) (credentials.TransportCredentials, error) {
+	if !c.tls {
+		return nil, nil
+	}
+	reloader, err := certs.NewReloader(c.tlsCert, c.tlsKey, c.tlsCA, c.tlsReloadInterval, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls config: %v", err.Error())
+	}
+	return credentials.NewTLS(reloader.ServerConfig(c.tlsClientAuth)), nil
+}
+
+// dialCredentials returns the dial option securing the connection to addr.
+// Failed certificate reloads are logged with logger.
+func dialCredentials(addr string, c *config, logger *zap.Logger) (grpc.DialOption, error) {
+	if !c.tls {
+		return grpc.WithInsecure(), nil
+	}
+
+	serverName := c.tlsServerName
+	if serverName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get server name: %v", err.Error())
+		}
+		serverName = host
+	}
+
+	reloader, err := certs.NewReloader(c.tlsCert, c.tlsKey, c.tlsCA, c.tlsReloadInterval, logger)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load tls config: %v", err.Error())
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(reloader.ClientConfig(serverName))), nil
+}